@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/valyala/fasthttp"
+)
+
+// abortAfterKey is the user value holding the ?abort_after= byte count
+const abortAfterKey = "hpdummy_abort_after"
+
+var errAborted = errors.New("response aborted")
+
+// parseAbortAfter validates ?abort_after=BYTES and records it for the body
+// senders, which then close the connection once that many body bytes are
+// sent, leaving a truncated response
+func parseAbortAfter(ctx *fasthttp.RequestCtx) error {
+	v := ctx.QueryArgs().Peek("abort_after")
+	if len(v) == 0 {
+		return nil
+	}
+	n, err := parseSize(b2s(v))
+	if err != nil {
+		return errors.New("abort_after must be a byte count, e.g. 512 or 64K")
+	}
+	ctx.SetUserValue(abortAfterKey, n)
+	return nil
+}
+
+// abortAfter returns the number of body bytes after which the response is
+// cut off, if ?abort_after= was given
+func abortAfter(ctx *fasthttp.RequestCtx) (int64, bool) {
+	n, ok := ctx.UserValue(abortAfterKey).(int64)
+	return n, ok
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// anythingJSON mirrors the httpbin /anything response layout
+type anythingJSON struct {
+	Args    map[string]interface{} `json:"args"`
+	Data    string                 `json:"data"`
+	Files   map[string]interface{} `json:"files"`
+	Form    map[string]interface{} `json:"form"`
+	Headers map[string]string      `json:"headers"`
+	JSON    interface{}            `json:"json"`
+	Method  string                 `json:"method"`
+	Origin  string                 `json:"origin"`
+	URL     string                 `json:"url"`
+}
+
+// anythingHandler echoes method, args, form fields, files and a decoded
+// JSON body for /anything and every path below it, for any method
+func anythingHandler(ctx *fasthttp.RequestCtx) {
+	resp := &anythingJSON{
+		Args:    argsToMap(ctx.QueryArgs()),
+		Files:   map[string]interface{}{},
+		Form:    map[string]interface{}{},
+		Headers: make(map[string]string),
+		Method:  string(ctx.Method()),
+		Origin:  ctx.RemoteIP().String(),
+		URL:     string(ctx.URI().FullURI()),
+	}
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		resp.Headers[string(k)] = string(v)
+	})
+
+	contentType := b2s(ctx.Request.Header.ContentType())
+	switch {
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		form, err := ctx.MultipartForm()
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		for name, values := range form.Value {
+			resp.Form[name] = collapse(values)
+		}
+		for name, headers := range form.File {
+			contents := make([]string, 0, len(headers))
+			for _, fh := range headers {
+				b, err := readFormFile(fh)
+				if err != nil {
+					ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+					return
+				}
+				contents = append(contents, string(b))
+			}
+			resp.Files[name] = collapse(contents)
+		}
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		resp.Form = argsToMap(ctx.PostArgs())
+	default:
+		resp.Data = string(ctx.PostBody())
+		if len(resp.Data) > 0 {
+			var v interface{}
+			if json.Unmarshal(ctx.PostBody(), &v) == nil {
+				resp.JSON = v
+			}
+		}
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, resp)
+}
+
+// argsToMap converts args to a map where repeated keys become a list of values
+func argsToMap(args *fasthttp.Args) map[string]interface{} {
+	values := make(map[string][]string)
+	args.VisitAll(func(k, v []byte) {
+		values[string(k)] = append(values[string(k)], string(v))
+	})
+
+	m := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		m[k] = collapse(v)
+	}
+	return m
+}
+
+// collapse returns a single value as is and multiple values as a list,
+// the same way httpbin does
+func collapse(values []string) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
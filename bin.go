@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/valyala/fasthttp"
+)
+
+// pattern is repeated to generate the /bin payloads, the byte at any
+// offset is pattern[offset%len(pattern)] so ranges never need the bytes
+// that come before them
+const pattern = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+var errInvalidSize = errors.New("invalid size")
+
+// patternReader yields n bytes of the repeated pattern starting at offset
+type patternReader struct {
+	pattern string
+	offset  int64
+	n       int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+
+	start := int(r.offset % int64(len(r.pattern)))
+	n := copy(p, r.pattern[start:])
+	for n < len(p) {
+		n += copy(p[n:], r.pattern)
+	}
+
+	r.offset += int64(n)
+	r.n -= int64(n)
+	return n, nil
+}
+
+func newPatternReader(offset, n int64) io.Reader {
+	return &patternReader{pattern: pattern, offset: offset, n: n}
+}
+
+// binHandler serves /bin/{size}, size bytes of the repeating pattern, of
+// incompressible pseudo-random data with ?random=true&seed= or of a mix of
+// both with ?compressibility=0..100.
+// Range requests get 206 responses, multiple ranges as multipart/byteranges.
+// Content that is the same on every request (the pattern, or random data
+// with an explicit seed) gets a strong ETag so resumed downloads can use
+// If-Range. Full pattern payloads up to 1G carry their SHA-256 in
+// X-Content-SHA256 and Digest. ?encoding=gzip compresses the payload on the fly,
+// ?content_type= replaces the Content-Type and ?filename= adds an attachment
+// Content-Disposition. ?chunked=true&chunk_size=&delay= paces the payload
+// in flushed chunks of fixed, random or listed sizes, followed by
+// ?trailers=k1:v1,k2:v2 and with ?checksum=trailer the X-Content-SHA256
+// trailer. ?abort_after=BYTES cuts any of them off.
+func binHandler(ctx *fasthttp.RequestCtx) {
+	size, err := parseSize(strings.TrimPrefix(b2s(ctx.Path()), "/bin/"))
+	if err != nil {
+		ctx.Error("expected /bin/{size}, e.g. /bin/64K or /bin/1G", fasthttp.StatusBadRequest)
+		return
+	}
+
+	if err := parseAbortAfter(ctx); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	args := ctx.QueryArgs()
+	var src contentSource = newPatternReader
+	etag := "bin-" + strconv.FormatInt(size, 10)
+	seeded := true
+	switch {
+	case args.Has("compressibility"):
+		compressibility, err := intArg(args, "compressibility", 100)
+		if err != nil || compressibility < 0 || compressibility > 100 {
+			ctx.Error("compressibility must be between 0 and 100", fasthttp.StatusBadRequest)
+			return
+		}
+		if src, err = mixedSource(ctx, compressibility); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		etag += "-mix" + strconv.Itoa(compressibility) + "-" + string(args.Peek("seed"))
+	case args.GetBool("random"):
+		if src, err = randomSource(ctx); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		etag += "-random-" + string(args.Peek("seed"))
+	default:
+		seeded = false
+	}
+
+	contentType := "application/octet-stream"
+	if v := args.Peek("content_type"); len(v) > 0 {
+		if !validHeaderValue(v) {
+			ctx.Error("content_type must not contain control characters", fasthttp.StatusBadRequest)
+			return
+		}
+		contentType = string(v)
+	}
+	if v := args.Peek("filename"); len(v) > 0 {
+		if !validHeaderValue(v) {
+			ctx.Error("filename must not contain control characters", fasthttp.StatusBadRequest)
+			return
+		}
+		ctx.Response.Header.Set(fasthttp.HeaderContentDisposition, contentDisposition(string(v)))
+	}
+
+	if enc := args.Peek("encoding"); len(enc) > 0 {
+		if string(enc) != "gzip" {
+			ctx.Error("encoding must be gzip", fasthttp.StatusBadRequest)
+			return
+		}
+		level, err := intArg(args, "level", gzip.DefaultCompression)
+		if err != nil || level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			ctx.Error("level must be between -2 and 9", fasthttp.StatusBadRequest)
+			return
+		}
+		serveGzip(ctx, contentType, size, src, level)
+		return
+	}
+
+	if args.GetBool("chunked") {
+		sizer, largest, varying, err := parseChunkSizes(args)
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		delay, err := millisArg(args, "delay")
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		trailers, err := parseTrailers(string(args.Peek("trailers")))
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		serveChunked(ctx, contentType, size, src, sizer, largest, varying, delay, trailers)
+		return
+	}
+
+	if !seeded || args.Has("seed") {
+		setRangeValidators(ctx, `"`+etag+`"`)
+	}
+
+	// Partial responses would need the digest of each range
+	if !seeded && len(ctx.Request.Header.Peek(fasthttp.HeaderRange)) == 0 && !wantsChecksumTrailer(ctx) {
+		setPatternDigest(ctx, size)
+	}
+
+	serveContent(ctx, contentType, size, src)
+}
+
+// validHeaderValue reports whether v can be sent in a header as is
+func validHeaderValue(v []byte) bool {
+	for _, c := range v {
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// contentDisposition builds an attachment Content-Disposition for name,
+// non-ASCII names get an RFC 6266 filename* with an ASCII fallback
+func contentDisposition(name string) string {
+	fallback := make([]byte, 0, len(name))
+	ascii := true
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c >= 0x80:
+			ascii = false
+			fallback = append(fallback, '_')
+		case c == '"' || c == '\\':
+			fallback = append(fallback, '\\', c)
+		default:
+			fallback = append(fallback, c)
+		}
+	}
+
+	v := `attachment; filename="` + string(fallback) + `"`
+	if !ascii {
+		v += "; filename*=UTF-8''" + url.PathEscape(name)
+	}
+	return v
+}
+
+// serveGzip streams size bytes of src gzip-compressed at the given level.
+// The compressed length isn't known up front so the body is chunked and
+// Range is ignored, X-Uncompressed-Content-Length tells the payload size.
+func serveGzip(ctx *fasthttp.RequestCtx, contentType string, size int64, src contentSource, level int) {
+	headerDelay, bodyDelay, err := phaseDelays(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if !sleepRequest(ctx, headerDelay) {
+		return
+	}
+
+	ctx.SetContentType(contentType)
+	ctx.Response.Header.Set(fasthttp.HeaderContentEncoding, "gzip")
+	ctx.Response.Header.Set("X-Uncompressed-Content-Length", strconv.FormatInt(size, 10))
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	if ctx.IsHead() {
+		ctx.Response.SkipBody = true
+		return
+	}
+
+	setBodyStreamWriter(ctx, bodyDelay, func(w *bufio.Writer) {
+		zw, _ := gzip.NewWriterLevel(w, level)
+		if _, err := io.Copy(zw, src(0, size)); err != nil {
+			return
+		}
+		zw.Close()
+	})
+}
+
+// serveChunked streams size bytes of src chunked, flushing chunks of the
+// sizes given by sizer and pausing delay between them, for slow large
+// downloads. Range is ignored. Trailers are sent after the last chunk.
+func serveChunked(ctx *fasthttp.RequestCtx, contentType string, size int64, src contentSource, sizer chunkSizer, largest int, varying bool, delay time.Duration, trailers []trailer) {
+	headerDelay, bodyDelay, err := phaseDelays(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if !sleepRequest(ctx, headerDelay) {
+		return
+	}
+
+	ctx.SetContentType(contentType)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	if ctx.IsHead() {
+		ctx.Response.SkipBody = true
+		return
+	}
+
+	// The checksum trailer's value is filled in once the body is written,
+	// sendChunked only appends the trailers after that
+	var h hash.Hash
+	if wantsChecksumTrailer(ctx) {
+		h = sha256.New()
+		trailers = append(trailers, trailer{name: checksumHeader})
+	}
+
+	body := func(w *bufio.Writer) {
+		r := src(0, size)
+		buf := make([]byte, largest)
+
+		next := time.Now()
+		for first := true; ; first = false {
+			// Sleep to absolute deadlines so slow writes don't stretch
+			// the total duration
+			if !first {
+				next = next.Add(delay)
+				if !sleepStream(time.Until(next)) {
+					return
+				}
+			}
+
+			n, err := io.ReadFull(r, buf[:sizer()])
+			if n > 0 {
+				if h != nil {
+					h.Write(buf[:n])
+				}
+				w.Write(buf[:n])
+				if ferr := w.Flush(); ferr != nil {
+					return
+				}
+			}
+			if err != nil {
+				if h != nil {
+					trailers[len(trailers)-1].value = hex.EncodeToString(h.Sum(nil))
+				}
+				return
+			}
+		}
+	}
+
+	// fasthttp can't send trailers after a streamed body and re-chunks it
+	// in its own copy buffer, varying sizes need every flush to be a chunk
+	if len(trailers) > 0 || varying {
+		sendChunked(ctx, bodyDelay, trailers, body)
+		return
+	}
+	setBodyStreamWriter(ctx, bodyDelay, body)
+}
+
+// rangeHandler serves /range/{n}, n bytes of the /bin pattern with strong
+// validators so If-Range can be exercised: a Range is only honored when
+// If-Range is missing or matches the ETag or Last-Modified, otherwise the
+// full content is sent with 200.
+func rangeHandler(ctx *fasthttp.RequestCtx) {
+	size, err := parseSize(strings.TrimPrefix(b2s(ctx.Path()), "/range/"))
+	if err != nil {
+		ctx.Error("expected /range/{n}, e.g. /range/1024 or /range/10M", fasthttp.StatusBadRequest)
+		return
+	}
+
+	setRangeValidators(ctx, `"range-`+strconv.FormatInt(size, 10)+`"`)
+	serveContent(ctx, "application/octet-stream", size, newPatternReader)
+}
+
+// setRangeValidators sets a strong ETag and Last-Modified on the response
+// and drops the Range of a request whose If-Range doesn't match them, so
+// the full content is served instead
+func setRangeValidators(ctx *fasthttp.RequestCtx, etag string) {
+	lastModified := startTime.Add(clockSkew).UTC().Truncate(time.Second)
+	ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+	ctx.Response.Header.SetLastModified(lastModified)
+
+	if ifRange := ctx.Request.Header.Peek(fasthttp.HeaderIfRange); len(ifRange) > 0 && !ifRangeMatches(b2s(ifRange), etag, lastModified) {
+		ctx.Request.Header.Del(fasthttp.HeaderRange)
+	}
+}
+
+// ifRangeMatches evaluates an If-Range value, an entity tag compared
+// strongly or an HTTP date that must equal Last-Modified (RFC 9110, 13.1.5)
+func ifRangeMatches(value, etag string, lastModified time.Time) bool {
+	if strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "W/") {
+		return value == etag && !strings.HasPrefix(etag, "W/")
+	}
+
+	t, err := fasthttp.ParseHTTPDate([]byte(value))
+	return err == nil && t.Equal(lastModified)
+}
+
+// parseSize parses a byte count with an optional binary unit suffix:
+// 512, 64K, 10M, 1G, 1T (KB/KiB style suffixes are accepted too)
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/mult {
+		return 0, errInvalidSize
+	}
+	return n * mult, nil
+}
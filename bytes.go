@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errInvalidSeed = errors.New("seed must be an unsigned integer")
+
+// randomReader yields n pseudo-random bytes of the stream identified by
+// seed starting at offset. Every 8-byte word is splitmix64 of its index, so
+// any offset can be produced without generating what comes before it and
+// Range requests stay consistent with the full body.
+type randomReader struct {
+	seed   uint64
+	offset int64
+	n      int64
+}
+
+func (r *randomReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+
+	var word [8]byte
+	n := 0
+	for n < len(p) {
+		i := r.offset + int64(n)
+		// Whole aligned words go straight into p, which is most of them
+		if i%8 == 0 && len(p)-n >= 8 {
+			binary.LittleEndian.PutUint64(p[n:], splitmix64(r.seed+uint64(i/8)))
+			n += 8
+			continue
+		}
+		binary.LittleEndian.PutUint64(word[:], splitmix64(r.seed+uint64(i/8)))
+		n += copy(p[n:], word[i%8:])
+	}
+
+	r.offset += int64(n)
+	r.n -= int64(n)
+	return n, nil
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// bytesHandler serves /bytes/{n}?seed=, n incompressible pseudo-random
+// bytes. The same seed always gives the same bytes, without one a random
+// seed is picked; either way it's returned in X-Seed. Ranges are honored
+// like on /bin.
+func bytesHandler(ctx *fasthttp.RequestCtx) {
+	size, err := parseSize(strings.TrimPrefix(b2s(ctx.Path()), "/bytes/"))
+	if err != nil {
+		ctx.Error("expected /bytes/{n}, e.g. /bytes/1024 or /bytes/10M", fasthttp.StatusBadRequest)
+		return
+	}
+
+	src, err := randomSource(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	serveContent(ctx, "application/octet-stream", size, src)
+}
+
+// randomSource returns a content source of pseudo-random bytes seeded by
+// ?seed= or a random seed, reported in X-Seed
+func randomSource(ctx *fasthttp.RequestCtx) (contentSource, error) {
+	seed, err := requestSeed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func(offset, n int64) io.Reader {
+		return &randomReader{seed: seed, offset: offset, n: n}
+	}, nil
+}
+
+// mixedSource is randomSource mixed with the pattern to the given
+// compressibility
+func mixedSource(ctx *fasthttp.RequestCtx, compressibility int) (contentSource, error) {
+	seed, err := requestSeed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func(offset, n int64) io.Reader {
+		return newMixedReader(seed, compressibility, offset, n)
+	}, nil
+}
+
+// requestSeed returns ?seed= or a random seed and reports it in X-Seed
+func requestSeed(ctx *fasthttp.RequestCtx) (uint64, error) {
+	seed := rand.Uint64()
+	if v := ctx.QueryArgs().Peek("seed"); len(v) > 0 {
+		var err error
+		if seed, err = strconv.ParseUint(b2s(v), 10, 64); err != nil {
+			return 0, errInvalidSeed
+		}
+	}
+
+	ctx.Response.Header.Set("X-Seed", strconv.FormatUint(seed, 10))
+	return seed, nil
+}
+
+// compressibilityBlock is the granularity at which random and repeated
+// bytes are mixed, well below deflate's 32 KiB window
+const compressibilityBlock = 1024
+
+// mixedReader yields n bytes starting at offset where the first part of
+// every block is random and the rest is the repeated pattern. The random
+// share sets how well the data compresses: compressibility 0 is all
+// random, 100 is all pattern.
+type mixedReader struct {
+	random  randomReader
+	pattern patternReader
+	// randomBytes is the number of random bytes at the start of each block
+	randomBytes int64
+	offset      int64
+	n           int64
+}
+
+func newMixedReader(seed uint64, compressibility int, offset, n int64) io.Reader {
+	return &mixedReader{
+		random:      randomReader{seed: seed},
+		pattern:     patternReader{pattern: pattern},
+		randomBytes: compressibilityBlock * int64(100-compressibility) / 100,
+		offset:      offset,
+		n:           n,
+	}
+}
+
+func (r *mixedReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+
+	n := 0
+	for n < len(p) {
+		at := r.offset + int64(n)
+		in := at % compressibilityBlock
+
+		end := int64(compressibilityBlock)
+		if in < r.randomBytes {
+			end = r.randomBytes
+		}
+		chunk := p[n:]
+		if int64(len(chunk)) > end-in {
+			chunk = chunk[:end-in]
+		}
+
+		if in < r.randomBytes {
+			r.random.offset, r.random.n = at, int64(len(chunk))
+			io.ReadFull(&r.random, chunk)
+		} else {
+			r.pattern.offset, r.pattern.n = at, int64(len(chunk))
+			io.ReadFull(&r.pattern, chunk)
+		}
+		n += len(chunk)
+	}
+
+	r.offset += int64(n)
+	r.n -= int64(n)
+	return n, nil
+}
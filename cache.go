@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// startTime is used as Last-Modified of /cache, so revalidation keeps
+// getting 304 for the whole lifetime of the process
+var startTime = time.Now()
+
+// cacheETag is the validator of /cache, stable while the process runs
+var cacheETag = `"` + strconv.FormatInt(startTime.UnixNano(), 16) + `"`
+
+// cacheHandler answers 304 to conditional requests that still match and
+// otherwise echoes the request with Last-Modified and ETag validators
+func cacheHandler(ctx *fasthttp.RequestCtx) {
+	lastModified := startTime.Add(clockSkew).UTC().Truncate(time.Second)
+
+	if inm := ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch); len(inm) > 0 {
+		if etagListMatches(b2s(inm), cacheETag, false) {
+			notModified(ctx, cacheETag, lastModified)
+			return
+		}
+	} else if ims := ctx.Request.Header.Peek(fasthttp.HeaderIfModifiedSince); len(ims) > 0 {
+		if t, err := fasthttp.ParseHTTPDate(ims); err == nil && !lastModified.After(t) {
+			notModified(ctx, cacheETag, lastModified)
+			return
+		}
+	}
+
+	echoHandler(ctx)
+	ctx.Response.Header.Set(fasthttp.HeaderETag, cacheETag)
+	ctx.Response.Header.SetLastModified(lastModified)
+}
+
+// cacheMaxAgeHandler serves /cache/{seconds} with Cache-Control max-age and
+// the matching Expires
+func cacheMaxAgeHandler(ctx *fasthttp.RequestCtx) {
+	seconds, err := strconv.Atoi(strings.TrimPrefix(b2s(ctx.Path()), "/cache/"))
+	if err != nil || seconds < 0 {
+		ctx.Error("expected /cache/{seconds}", fasthttp.StatusBadRequest)
+		return
+	}
+
+	echoHandler(ctx)
+	ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "public, max-age="+strconv.Itoa(seconds))
+	ctx.Response.Header.Set(fasthttp.HeaderExpires, string(fasthttp.AppendHTTPDate(nil, serverNow().Add(time.Duration(seconds)*time.Second))))
+}
+
+// etagHandler serves /etag/{etag}. If-None-Match uses weak comparison and
+// answers 304, If-Match uses strong comparison and answers 412 on mismatch.
+// A W/ prefix in the path makes the served ETag weak.
+func etagHandler(ctx *fasthttp.RequestCtx) {
+	value := strings.TrimPrefix(b2s(ctx.Path()), "/etag/")
+	if value == "" {
+		ctx.Error("expected /etag/{etag}", fasthttp.StatusBadRequest)
+		return
+	}
+
+	opaque := strings.TrimPrefix(value, "W/")
+	if !isETagOpaque(opaque) {
+		ctx.Error("etag must be etagc characters, without quotes or commas", fasthttp.StatusBadRequest)
+		return
+	}
+
+	etag := `"` + opaque + `"`
+	if strings.HasPrefix(value, "W/") {
+		etag = "W/" + etag
+	}
+
+	if inm := ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch); len(inm) > 0 {
+		if etagListMatches(b2s(inm), etag, false) {
+			notModified(ctx, etag, time.Time{})
+			return
+		}
+	} else if im := ctx.Request.Header.Peek(fasthttp.HeaderIfMatch); len(im) > 0 {
+		if !etagListMatches(b2s(im), etag, true) {
+			ctx.Error("precondition failed", fasthttp.StatusPreconditionFailed)
+			ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+			return
+		}
+	}
+
+	echoHandler(ctx)
+	ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+}
+
+// isETagOpaque reports whether s is made of etagc (RFC 9110, 8.8.3), which
+// excludes quotes and control characters. Commas are rejected as well,
+// they would split the tag in If-Match and If-None-Match lists.
+func isETagOpaque(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c == '"' || c == ',' || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+func notModified(ctx *fasthttp.RequestCtx, etag string, lastModified time.Time) {
+	ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+	if !lastModified.IsZero() {
+		ctx.Response.Header.SetLastModified(lastModified)
+	}
+	ctx.SetStatusCode(fasthttp.StatusNotModified)
+}
+
+// etagListMatches reports whether a comma separated If-Match/If-None-Match
+// list contains etag. Strong comparison never matches weak tags, weak
+// comparison ignores the W/ prefix on both sides (RFC 9110, 8.8.3.2).
+func etagListMatches(list, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strong {
+			if candidate == etag && !strings.HasPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
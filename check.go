@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/valyala/fasthttp/reuseport"
+)
+
+// Outcomes of a preflight check, only checkFail makes the run fail
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// minOpenFiles is the open file limit below which a load test is likely
+// to run out of sockets
+const minOpenFiles = 65536
+
+// checkResult is the outcome of a single preflight check
+type checkResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// checkReport is what the check subcommand prints
+type checkReport struct {
+	OK     bool           `json:"ok"`
+	Checks []*checkResult `json:"checks"`
+}
+
+func (r *checkReport) add(name, status, detail string) {
+	r.Checks = append(r.Checks, &checkResult{Name: name, Status: status, Detail: detail})
+	if status == checkFail {
+		r.OK = false
+	}
+}
+
+// runPreflight validates the parsed flags, binds and releases every
+// configured listen address and checks resource limits without starting
+// the server. It prints a JSON report and returns the exit code.
+func runPreflight(addr string) int {
+	r := &checkReport{OK: true}
+
+	checkConfig(r)
+
+	if ln, err := reuseport.Listen("tcp4", addr); err != nil {
+		r.add("listen:http", checkFail, err.Error())
+	} else {
+		ln.Close()
+		r.add("listen:http", checkOK, addr)
+	}
+	for _, l := range []struct{ name, addr string }{{"listen:ftp", ftpAddr}, {"listen:redis", redisAddr}} {
+		if l.addr == "" {
+			r.add(l.name, checkSkip, "disabled")
+			continue
+		}
+		ln, err := net.Listen("tcp", l.addr)
+		if err != nil {
+			r.add(l.name, checkFail, err.Error())
+			continue
+		}
+		ln.Close()
+		r.add(l.name, checkOK, l.addr)
+	}
+
+	// The server only speaks plain HTTP, there are no certificates to verify
+	r.add("certificates", checkSkip, "TLS is not supported")
+
+	if soft, hard, err := openFilesLimit(); err != nil {
+		r.add("ulimit:nofile", checkSkip, err.Error())
+	} else if soft < minOpenFiles {
+		r.add("ulimit:nofile", checkWarn, fmt.Sprintf("soft limit %d (hard %d) is below %d", soft, hard, minOpenFiles))
+	} else {
+		r.add("ulimit:nofile", checkOK, fmt.Sprintf("soft limit %d (hard %d)", soft, hard))
+	}
+
+	out, _ := json.MarshalIndent(r, "", "  ")
+	fmt.Println(string(out))
+
+	if !r.OK {
+		return 1
+	}
+	return 0
+}
+
+// checkConfig validates flag values the flag package can't range check
+func checkConfig(r *checkReport) {
+	var problems []string
+	if hookTimeout <= 0 {
+		problems = append(problems, "-hook-timeout must be positive")
+	}
+	if watchdogInterval < 0 || watchdogTimeout <= 0 || watchdogExitAfter < 0 {
+		problems = append(problems, "-watchdog-interval and -watchdog-exit-after must not be negative, -watchdog-timeout must be positive")
+	}
+	if rate := costSampleRate.Get(); rate < 0 || rate > 1 {
+		problems = append(problems, "-cost-sample must be between 0 and 1")
+	}
+	if idleRST < 0 {
+		problems = append(problems, "-idle-rst must not be negative")
+	}
+	if connThreshold.Get() < 0 {
+		problems = append(problems, "-conn-threshold must not be negative")
+	}
+	if teeMaxBytes < 0 {
+		problems = append(problems, "-tee-max-bytes must not be negative")
+	}
+	if err := loadRobots(); err != nil {
+		problems = append(problems, "-robots-file: "+err.Error())
+	}
+	if teeDir != "" {
+		if f, err := os.CreateTemp(teeDir, ".preflight-*"); err != nil {
+			problems = append(problems, "-tee-dir is not writable: "+err.Error())
+		} else {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+
+	if len(problems) == 0 {
+		r.add("config", checkOK, "")
+		return
+	}
+	for _, p := range problems {
+		r.add("config", checkFail, p)
+	}
+}
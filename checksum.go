@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const checksumHeader = "X-Content-SHA256"
+
+// checksumStatusHeader tells why a pattern payload went without its digest
+const checksumStatusHeader = "X-Content-SHA256-Status"
+
+// Bounds of the pattern payload digests: the cache size, the largest
+// payload ever hashed, and the background hashing workers and their queue
+const (
+	maxPatternDigests     = 1024
+	maxPatternDigestSize  = 1 << 30
+	patternDigestWorkers  = 2
+	patternDigestQueueLen = 16
+)
+
+// checksumSyncMax is the largest pattern payload whose digest is computed
+// while the request waits, bigger ones are computed in the background and
+// advertised once ready
+var checksumSyncMax int64
+
+var patternDigests = struct {
+	sync.Mutex
+	sums    map[int64][]byte
+	pending map[int64]bool
+	queue   chan int64
+	start   sync.Once
+}{
+	sums:    make(map[int64][]byte),
+	pending: make(map[int64]bool),
+	queue:   make(chan int64, patternDigestQueueLen),
+}
+
+// patternDigest returns the SHA-256 of size bytes of the /bin pattern.
+// The pattern payload is a pure function of its size so every digest is
+// computed once and cached. Unless compute is set only cached digests are
+// returned. Sizes above checksumSyncMax return nil until a background
+// worker has hashed them, sizes above maxPatternDigestSize always do.
+func patternDigest(size int64, compute bool) []byte {
+	patternDigests.Lock()
+	sum, ok := patternDigests.sums[size]
+	if ok || !compute || size > maxPatternDigestSize || patternDigests.pending[size] {
+		patternDigests.Unlock()
+		return sum
+	}
+	if size <= checksumSyncMax {
+		patternDigests.pending[size] = true
+		patternDigests.Unlock()
+		return computePatternDigest(size)
+	}
+
+	// A full queue drops the size, a later request queues it again
+	patternDigests.start.Do(func() {
+		for i := 0; i < patternDigestWorkers; i++ {
+			go patternDigestWorker()
+		}
+	})
+	select {
+	case patternDigests.queue <- size:
+		patternDigests.pending[size] = true
+	default:
+	}
+	patternDigests.Unlock()
+	return nil
+}
+
+func patternDigestWorker() {
+	for size := range patternDigests.queue {
+		computePatternDigest(size)
+	}
+}
+
+func computePatternDigest(size int64) []byte {
+	h := sha256.New()
+	io.CopyBuffer(h, newPatternReader(0, size), make([]byte, 1<<20))
+	sum := h.Sum(nil)
+
+	patternDigests.Lock()
+	defer patternDigests.Unlock()
+	delete(patternDigests.pending, size)
+	if len(patternDigests.sums) >= maxPatternDigests {
+		for k := range patternDigests.sums {
+			delete(patternDigests.sums, k)
+			break
+		}
+	}
+	patternDigests.sums[size] = sum
+	return sum
+}
+
+// setPatternDigest advertises the digest of a full pattern payload in
+// X-Content-SHA256 (hex) and Digest (RFC 3230), when it is known. HEAD
+// requests only get digests already cached, they never start hashing.
+// Without a digest X-Content-SHA256-Status says "pending", or "omitted"
+// for payloads above maxPatternDigestSize, which are never hashed.
+func setPatternDigest(ctx *fasthttp.RequestCtx, size int64) {
+	sum := patternDigest(size, !ctx.IsHead())
+	if sum == nil {
+		status := "pending"
+		if size > maxPatternDigestSize {
+			status = "omitted"
+		}
+		ctx.Response.Header.Set(checksumStatusHeader, status)
+		return
+	}
+	ctx.Response.Header.Set(checksumHeader, hex.EncodeToString(sum))
+	ctx.Response.Header.Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+}
+
+// wantsChecksumTrailer reports whether the request asked for the body's
+// SHA-256 as a trailer with ?checksum=trailer
+func wantsChecksumTrailer(ctx *fasthttp.RequestCtx) bool {
+	return string(ctx.QueryArgs().Peek("checksum")) == "trailer"
+}
+
+// sendWithChecksumTrailer sends size bytes of body chunked, hashing them
+// on the way, and ends with an X-Content-SHA256 trailer so clients can
+// verify the payload end to end without buffering it. The connection is
+// hijacked to write the trailer and closed afterwards; delay holds back
+// the body like sendWithBodyDelay.
+func sendWithChecksumTrailer(ctx *fasthttp.RequestCtx, delay time.Duration, body io.Reader, size int) {
+	ctx.Response.Header.SetContentLength(-1)
+	ctx.Response.Header.Set(fasthttp.HeaderTrailer, checksumHeader)
+	ctx.Response.SetConnectionClose()
+	header := hijackHeader(ctx)
+
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(c net.Conn) {
+		w := bufio.NewWriterSize(c, 64<<10)
+		w.Write(header)
+		if err := w.Flush(); err != nil {
+			return
+		}
+		if !sleepStream(delay) {
+			return
+		}
+
+		h := sha256.New()
+		buf := make([]byte, 32<<10)
+		r := io.LimitReader(body, int64(size))
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				h.Write(buf[:n])
+				fmt.Fprintf(w, "%x\r\n", n)
+				w.Write(buf[:n])
+				w.WriteString("\r\n")
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return
+			}
+		}
+
+		fmt.Fprintf(w, "0\r\n%s: %s\r\n\r\n", checksumHeader, hex.EncodeToString(h.Sum(nil)))
+		w.Flush()
+	})
+}
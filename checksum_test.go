@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"testing"
+)
+
+func TestChecksumTrailer(t *testing.T) {
+	ln := serveTest(t)
+
+	for _, path := range []string{
+		"/bin/100000?checksum=trailer",
+		"/bin/100000?checksum=trailer&body_delay=1",
+		"/bin/100000?checksum=trailer&chunked=true&chunk_size=7K",
+	} {
+		resp, body := roundTrip(t, ln, newRequest(t, "GET", path, nil))
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status = %d", path, resp.StatusCode)
+			continue
+		}
+		if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+			t.Errorf("%s: Transfer-Encoding = %v, want chunked", path, resp.TransferEncoding)
+		}
+		if !bytes.Equal(body, patternBytes(0, 100000)) {
+			t.Errorf("%s: body differs from the pattern (%d bytes)", path, len(body))
+		}
+
+		sum := sha256.Sum256(body)
+		if got, want := resp.Trailer.Get(checksumHeader), hex.EncodeToString(sum[:]); got != want {
+			t.Errorf("%s: trailer %s = %q, want %q", path, checksumHeader, got, want)
+		}
+	}
+}
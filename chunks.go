@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Chunk sizes of /bin?chunked=true
+const (
+	defaultChunkSize = 16 << 10
+	maxChunkSize     = 1 << 20
+
+	// maxChunkSizes bounds the ?chunk_sizes= list
+	maxChunkSizes = 1024
+)
+
+var errInvalidChunkSize = errors.New("chunk_size must be a size or min-max between 1 and 1M, chunk_sizes a list of up to 1024 of them")
+
+// chunkSizer returns the size of the next chunk
+type chunkSizer func() int
+
+// parseChunkSizes parses the chunk sizes of /bin?chunked=true: a fixed
+// ?chunk_size=16K, a uniformly random ?chunk_size=min-max per chunk or a
+// ?chunk_sizes=100,4096,1 list cycled through. It also returns the
+// largest chunk and whether sizes vary.
+func parseChunkSizes(args *fasthttp.Args) (sizer chunkSizer, largest int, varying bool, err error) {
+	if list := b2s(args.Peek("chunk_sizes")); list != "" {
+		fields := strings.Split(list, ",")
+		if len(fields) > maxChunkSizes {
+			return nil, 0, false, errInvalidChunkSize
+		}
+		sizes := make([]int, len(fields))
+		for i, f := range fields {
+			n, err := parseChunkSize(f)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			sizes[i] = n
+			if n > largest {
+				largest = n
+			}
+		}
+		i := 0
+		return func() int {
+			n := sizes[i%len(sizes)]
+			i++
+			return n
+		}, largest, len(sizes) > 1, nil
+	}
+
+	spec := b2s(args.Peek("chunk_size"))
+	if spec == "" {
+		return func() int { return defaultChunkSize }, defaultChunkSize, false, nil
+	}
+	first, last, isRange := strings.Cut(spec, "-")
+	lo, err := parseChunkSize(first)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if !isRange {
+		return func() int { return lo }, lo, false, nil
+	}
+	hi, err := parseChunkSize(last)
+	if err != nil || hi < lo {
+		return nil, 0, false, errInvalidChunkSize
+	}
+	return func() int { return lo + rand.Intn(hi-lo+1) }, hi, hi > lo, nil
+}
+
+func parseChunkSize(s string) (int, error) {
+	n, err := parseSize(s)
+	if err != nil || n < 1 || n > maxChunkSize {
+		return 0, errInvalidChunkSize
+	}
+	return int(n), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// clockSkew offsets the server's notion of now in Date, Expires and
+// Last-Modified headers without touching the system clock
+var clockSkew time.Duration
+
+// serverNow is the current time as the emitted headers present it
+func serverNow() time.Time {
+	return time.Now().Add(clockSkew)
+}
+
+// withClockSkew sets a skewed Date header on every response. fasthttp's
+// own Date header is disabled on the server while a skew is configured.
+func withClockSkew(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	if clockSkew == 0 {
+		return h
+	}
+	return func(ctx *fasthttp.RequestCtx) {
+		h(ctx)
+
+		if !ctx.Hijacked() {
+			setSkewedDate(&ctx.Response.Header)
+		}
+	}
+}
+
+// setSkewedDate sets Date to serverNow(). fasthttp's setters ignore Date
+// as a header it manages itself, but keeps it when parsing one, so the
+// header is parsed back with the Date line added.
+func setSkewedDate(h *fasthttp.ResponseHeader) {
+	var prev fasthttp.ResponseHeader
+	h.CopyTo(&prev)
+	h.Del(fasthttp.HeaderDate)
+	raw := h.Header()
+	end := bytes.Index(raw, []byte("\r\n")) + 2
+
+	var buf bytes.Buffer
+	buf.Write(raw[:end])
+	buf.WriteString(fasthttp.HeaderDate + ": ")
+	buf.Write(fasthttp.AppendHTTPDate(nil, serverNow()))
+	buf.WriteString("\r\n")
+	buf.Write(raw[end:])
+	if err := h.Read(bufio.NewReader(&buf)); err != nil {
+		prev.CopyTo(h)
+		return
+	}
+
+	// Parsing a header without Content-Length implies a body read until
+	// close, which isn't what the handler left unset
+	h.SetContentLength(prev.ContentLength())
+	if !prev.ConnectionClose() {
+		h.ResetConnectionClose()
+	}
+}
+
+// hijackHeader snapshots the response header for handlers that hijack the
+// connection and write it themselves. withClockSkew doesn't see hijacked
+// responses, so the skewed Date is set here.
+func hijackHeader(ctx *fasthttp.RequestCtx) []byte {
+	if clockSkew != 0 {
+		setSkewedDate(&ctx.Response.Header)
+	}
+	return append([]byte(nil), ctx.Response.Header.Header()...)
+}
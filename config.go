@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Sources a setting's effective value can come from
+const (
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+	sourceRuntime = "runtime"
+)
+
+// runtimeSettings lists the flags that take effect when changed on a
+// running server through POST /admin/config
+var runtimeSettings = map[string]bool{
+	"quiet":           true,
+	"quiet-for":       true,
+	"compress":        true,
+	"conn-threshold":  true,
+	"cost-sample":     true,
+	"latency-profile": true,
+	"upload-max":      true,
+}
+
+// Runtime settings are read by every request while POST /admin/config may
+// change them, the flag values below are read and written atomically.
+// Settings of other types guard their values with a mutex.
+
+// atomicBool is a bool flag value
+type atomicBool struct{ v int32 }
+
+func (b *atomicBool) Get() bool {
+	return atomic.LoadInt32(&b.v) != 0
+}
+
+func (b *atomicBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	var i int32
+	if v {
+		i = 1
+	}
+	atomic.StoreInt32(&b.v, i)
+	return nil
+}
+
+func (b *atomicBool) String() string {
+	if b == nil {
+		return "false"
+	}
+	return strconv.FormatBool(b.Get())
+}
+
+// IsBoolFlag lets the flag be given without a value
+func (b *atomicBool) IsBoolFlag() bool { return true }
+
+// atomicInt64 is an int64 flag value
+type atomicInt64 struct{ v int64 }
+
+func (i *atomicInt64) Get() int64 {
+	return atomic.LoadInt64(&i.v)
+}
+
+func (i *atomicInt64) Set(s string) error {
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&i.v, v)
+	return nil
+}
+
+func (i *atomicInt64) String() string {
+	if i == nil {
+		return "0"
+	}
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// atomicFloat64 is a float64 flag value
+type atomicFloat64 struct{ bits uint64 }
+
+func (f *atomicFloat64) Get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+func (f *atomicFloat64) Set(s string) error {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+	return nil
+}
+
+func (f *atomicFloat64) String() string {
+	if f == nil {
+		return "0"
+	}
+	return strconv.FormatFloat(f.Get(), 'g', -1, 64)
+}
+
+// configSetting is the effective value of a single flag and where it came from
+type configSetting struct {
+	Value     string     `json:"value"`
+	Default   string     `json:"default"`
+	Source    string     `json:"source"`
+	Runtime   bool       `json:"runtime"`
+	Usage     string     `json:"usage"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+var errNotRuntimeSetting = errors.New("setting can't be changed at runtime")
+
+// overrides records when each runtime setting was last changed. configMu
+// guards it along with the flag set, which flag.Set writes to.
+var (
+	configMu  sync.Mutex
+	overrides = make(map[string]time.Time)
+)
+
+// effectiveConfig collects every flag with its provenance
+func effectiveConfig() map[string]*configSetting {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	setOnCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setOnCommandLine[f.Name] = true
+	})
+
+	settings := make(map[string]*configSetting)
+	flag.VisitAll(func(f *flag.Flag) {
+		s := &configSetting{
+			Value:   f.Value.String(),
+			Default: f.DefValue,
+			Source:  sourceDefault,
+			Runtime: runtimeSettings[f.Name],
+			Usage:   f.Usage,
+		}
+		if setOnCommandLine[f.Name] {
+			s.Source = sourceFlag
+		}
+		if at, ok := overrides[f.Name]; ok {
+			s.Source = sourceRuntime
+			s.UpdatedAt = &at
+		}
+		settings[f.Name] = s
+	})
+	return settings
+}
+
+// checkOverride reports whether overrideConfig would accept value for
+// name, it's parsed into a scratch value of the flag's type
+func checkOverride(name, value string) error {
+	if !runtimeSettings[name] {
+		return errNotRuntimeSetting
+	}
+	f := flag.Lookup(name)
+	scratch := reflect.New(reflect.TypeOf(f.Value).Elem()).Interface().(flag.Value)
+	return scratch.Set(value)
+}
+
+// overrideConfig changes a runtime setting on the running server
+func overrideConfig(name, value string) error {
+	if !runtimeSettings[name] {
+		return errNotRuntimeSetting
+	}
+
+	configMu.Lock()
+	err := flag.Set(name, value)
+	if err == nil {
+		overrides[name] = time.Now()
+	}
+	configMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	events.publish("config_override", map[string]interface{}{"name": name, "value": value})
+	return nil
+}
+
+// configHandler returns the effective configuration on GET and applies
+// runtime overrides given as query arguments on POST:
+// POST /admin/config?quiet-for=http,redis&conn-threshold=1000
+// Overrides are all applied or, when any is invalid, none.
+func configHandler(ctx *fasthttp.RequestCtx) {
+	if ctx.IsPost() {
+		args := ctx.QueryArgs()
+		var err error
+		args.VisitAll(func(k, v []byte) {
+			if err == nil {
+				if err = checkOverride(string(k), string(v)); err != nil {
+					err = fmt.Errorf("%s: %w", k, err)
+				}
+			}
+		})
+		if err == nil {
+			args.VisitAll(func(k, v []byte) {
+				if err == nil {
+					if err = overrideConfig(string(k), string(v)); err != nil {
+						err = fmt.Errorf("%s: %w", k, err)
+					}
+				}
+			})
+		}
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, effectiveConfig())
+}
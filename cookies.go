@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// cookiesHandler reports the cookies received with the request
+func cookiesHandler(ctx *fasthttp.RequestCtx) {
+	cookies := make(map[string]string)
+	ctx.Request.Header.VisitAllCookie(func(k, v []byte) {
+		cookies[string(k)] = string(v)
+	})
+
+	writeJSON(ctx, fasthttp.StatusOK, map[string]interface{}{"cookies": cookies})
+}
+
+// cookiesSetHandler sets a cookie for every query argument, or the one
+// given as /cookies/set/{name}/{value}, and redirects to /cookies
+func cookiesSetHandler(ctx *fasthttp.RequestCtx) {
+	var pathName, pathValue string
+	if rest := strings.TrimPrefix(b2s(ctx.Path()), "/cookies/set/"); rest != b2s(ctx.Path()) {
+		var ok bool
+		pathName, pathValue, ok = strings.Cut(rest, "/")
+		if !ok || pathName == "" {
+			ctx.Error("expected /cookies/set/{name}/{value}", fasthttp.StatusBadRequest)
+			return
+		}
+		if !isToken(pathName) || !isCookieValue(pathValue) {
+			ctx.Error("cookie names must be tokens and values cookie-octets", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	// Everything is checked before the first cookie is set, an invalid
+	// one would otherwise split the Set-Cookie header
+	valid := true
+	ctx.QueryArgs().VisitAll(func(k, v []byte) {
+		valid = valid && isToken(b2s(k)) && isCookieValue(b2s(v))
+	})
+	if !valid {
+		ctx.Error("cookie names must be tokens and values cookie-octets", fasthttp.StatusBadRequest)
+		return
+	}
+
+	if pathName != "" {
+		setCookie(ctx, pathName, pathValue)
+	}
+	ctx.QueryArgs().VisitAll(func(k, v []byte) {
+		setCookie(ctx, string(k), string(v))
+	})
+
+	ctx.Redirect("/cookies", fasthttp.StatusFound)
+}
+
+// cookiesDeleteHandler expires every cookie named in the query and
+// redirects to /cookies
+func cookiesDeleteHandler(ctx *fasthttp.RequestCtx) {
+	valid := true
+	ctx.QueryArgs().VisitAll(func(k, _ []byte) {
+		valid = valid && isToken(b2s(k))
+	})
+	if !valid {
+		ctx.Error("cookie names must be tokens", fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx.QueryArgs().VisitAll(func(k, _ []byte) {
+		c := fasthttp.AcquireCookie()
+		c.SetKeyBytes(k)
+		c.SetPath("/")
+		c.SetExpire(fasthttp.CookieExpireDelete)
+		ctx.Response.Header.SetCookie(c)
+		fasthttp.ReleaseCookie(c)
+	})
+
+	ctx.Redirect("/cookies", fasthttp.StatusFound)
+}
+
+// isCookieValue reports whether v is a cookie-value of RFC 6265, 4.1.1:
+// cookie-octets, optionally in double quotes
+func isCookieValue(v string) bool {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c <= ' ', c == '"', c == ',', c == ';', c == '\\', c >= 0x7f:
+			return false
+		}
+	}
+	return true
+}
+
+func setCookie(ctx *fasthttp.RequestCtx, name, value string) {
+	c := fasthttp.AcquireCookie()
+	c.SetKey(name)
+	c.SetValue(value)
+	c.SetPath("/")
+	ctx.Response.Header.SetCookie(c)
+	fasthttp.ReleaseCookie(c)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math/rand"
+	"runtime"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// costSampleRate is the fraction of requests whose CPU time and
+// allocations are measured, 0 disables sampling
+var costSampleRate atomicFloat64
+
+// Process-wide allocation counters, their deltas over a request include
+// whatever other goroutines allocated meanwhile, so per-endpoint numbers
+// are only approximate under concurrency
+var costMetrics = []metrics.Sample{
+	{Name: "/gc/heap/allocs:bytes"},
+	{Name: "/gc/heap/allocs:objects"},
+}
+
+// endpointCost accumulates the sampled cost of one endpoint
+type endpointCost struct {
+	samples      int64
+	cpu          time.Duration
+	allocBytes   uint64
+	allocObjects uint64
+}
+
+// endpointCostReport is the JSON view of an endpoint's average cost
+type endpointCostReport struct {
+	Samples         int64   `json:"samples"`
+	AvgCPU          string  `json:"avg_cpu"`
+	AvgAllocBytes   float64 `json:"avg_alloc_bytes"`
+	AvgAllocObjects float64 `json:"avg_alloc_objects"`
+}
+
+var (
+	costsMu sync.Mutex
+	costs   = make(map[string]*endpointCost)
+)
+
+// withCost measures a sampled fraction of requests. The handler is pinned
+// to its OS thread for the duration so the thread's CPU time approximates
+// the request's.
+func withCost(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if rate := costSampleRate.Get(); rate <= 0 || rand.Float64() >= rate {
+			h(ctx)
+			return
+		}
+
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		before := make([]metrics.Sample, len(costMetrics))
+		copy(before, costMetrics)
+		metrics.Read(before)
+		cpuBefore := threadCPUTime()
+
+		h(ctx)
+
+		cpu := threadCPUTime() - cpuBefore
+		after := make([]metrics.Sample, len(costMetrics))
+		copy(after, costMetrics)
+		metrics.Read(after)
+
+		recordCost(endpointLabel(b2s(ctx.Path())), cpu,
+			after[0].Value.Uint64()-before[0].Value.Uint64(),
+			after[1].Value.Uint64()-before[1].Value.Uint64())
+	}
+}
+
+func recordCost(endpoint string, cpu time.Duration, allocBytes, allocObjects uint64) {
+	costsMu.Lock()
+	defer costsMu.Unlock()
+
+	c := costs[endpoint]
+	if c == nil {
+		if len(costs) >= maxLatencyEndpoints {
+			endpoint = "other"
+		}
+		if c = costs[endpoint]; c == nil {
+			c = &endpointCost{}
+			costs[endpoint] = c
+		}
+	}
+	c.samples++
+	c.cpu += cpu
+	c.allocBytes += allocBytes
+	c.allocObjects += allocObjects
+}
+
+// statsHandler serves /stats, the average sampled cost per endpoint
+func statsHandler(ctx *fasthttp.RequestCtx) {
+	costsMu.Lock()
+	report := make(map[string]*endpointCostReport, len(costs))
+	for endpoint, c := range costs {
+		report[endpoint] = &endpointCostReport{
+			Samples:         c.samples,
+			AvgCPU:          (c.cpu / time.Duration(c.samples)).String(),
+			AvgAllocBytes:   float64(c.allocBytes) / float64(c.samples),
+			AvgAllocObjects: float64(c.allocObjects) / float64(c.samples),
+		}
+	}
+	costsMu.Unlock()
+
+	writeJSON(ctx, fasthttp.StatusOK, map[string]interface{}{
+		"sample_rate": costSampleRate.Get(),
+		"endpoints":   report,
+	})
+}
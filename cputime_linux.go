@@ -0,0 +1,19 @@
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// rusageThread is RUSAGE_THREAD, missing from the syscall package
+const rusageThread = 1
+
+// threadCPUTime returns the user and system CPU time consumed by the
+// calling OS thread
+func threadCPUTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(rusageThread, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
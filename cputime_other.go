@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "time"
+
+// threadCPUTime is not available outside Linux, sampled requests report
+// allocations only
+func threadCPUTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// maxDelay bounds every delay the server is asked to sleep
+	maxDelay = 60 * time.Second
+
+	// delayHeartbeatInterval is the time between heartbeats of /delay?stream=true
+	delayHeartbeatInterval = time.Second
+)
+
+// delayRange parses /delay/{ms} or /delay/{min}-{max} in milliseconds or
+// as durations, the path may be left at /delay when ?min=&max= are given
+// instead
+func delayRange(ctx *fasthttp.RequestCtx) (lo, hi time.Duration, err error) {
+	spec := strings.TrimPrefix(strings.TrimPrefix(b2s(ctx.Path()), "/delay"), "/")
+	args := ctx.QueryArgs()
+	if spec == "" {
+		spec = string(args.Peek("min")) + "-" + string(args.Peek("max"))
+	}
+
+	first, last, isRange := strings.Cut(spec, "-")
+	if lo, err = parseMillis(first); err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return lo, lo, nil
+	}
+	if hi, err = parseMillis(last); err != nil {
+		return 0, 0, err
+	}
+	if hi < lo {
+		return 0, 0, errInvalidMillis
+	}
+	return lo, hi, nil
+}
+
+// delayHandler serves /delay/{ms}, /delay/{min}-{max} and
+// /delay?min=&max=, sleeping a uniformly random duration within the range
+// before echoing the request together with the delay actually applied, in
+// milliseconds.
+// ?header_delay= adds to the delay, ?body_delay= holds back the body after
+// the headers are sent. ?status= replaces the 200 and ?size= replaces the
+// JSON with that many bytes of the /bin pattern, for delayed errors of a
+// chosen size such as /delay/500?status=503&size=64K.
+func delayHandler(ctx *fasthttp.RequestCtx) {
+	lo, hi, err := delayRange(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	args := ctx.QueryArgs()
+	status, err := intArg(args, "status", fasthttp.StatusOK)
+	if err != nil || status < 200 || status > 599 {
+		ctx.Error("status must be between 200 and 599", fasthttp.StatusBadRequest)
+		return
+	}
+	size := int64(-1)
+	if v := args.Peek("size"); len(v) > 0 {
+		if size, err = parseSize(b2s(v)); err != nil {
+			ctx.Error("size must be a byte count, e.g. 512 or 64K", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	headerDelay, bodyDelay, err := phaseDelays(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	delay := lo
+	if hi > lo {
+		delay += time.Duration(rand.Int63n(int64(hi - lo + 1)))
+	}
+
+	if args.GetBool("stream") {
+		streamDelay(ctx, status, delay, lo, hi)
+		return
+	}
+
+	if !sleepRequest(ctx, delay+headerDelay) {
+		return
+	}
+
+	if size >= 0 {
+		ctx.SetContentType("application/octet-stream")
+		ctx.SetStatusCode(status)
+		setBodyStream(ctx, newPatternReader(0, size), int(size), bodyDelay)
+		return
+	}
+
+	headers := make(map[string]string)
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		headers[string(k)] = string(v)
+	})
+	writeJSON(ctx, status, map[string]interface{}{
+		"args":    argsToMap(args),
+		"headers": headers,
+		"origin":  ctx.RemoteIP().String(),
+		"url":     string(ctx.URI().FullURI()),
+		"delay":   milliseconds(delay),
+		"min":     milliseconds(lo),
+		"max":     milliseconds(hi),
+	})
+
+	if bodyDelay > 0 {
+		body := append([]byte(nil), ctx.Response.Body()...)
+		ctx.Response.ResetBody()
+		sendWithBodyDelay(ctx, bodyDelay, bytes.NewReader(body), len(body))
+	}
+}
+
+// streamDelay sends the headers right away and a newline every second
+// while waiting delay, then the JSON summary. The newlines keep proxies
+// that need periodic bytes from timing out and leave the body valid JSON.
+func streamDelay(ctx *fasthttp.RequestCtx, status int, delay, lo, hi time.Duration) {
+	summary := map[string]interface{}{
+		"url":   string(ctx.URI().FullURI()),
+		"delay": milliseconds(delay),
+		"min":   milliseconds(lo),
+		"max":   milliseconds(hi),
+	}
+
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(status)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		start := time.Now()
+		for {
+			w.WriteByte('\n')
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+			remaining := delay - time.Since(start)
+			if remaining <= 0 {
+				break
+			}
+			if remaining > delayHeartbeatInterval {
+				remaining = delayHeartbeatInterval
+			}
+			if !sleepStream(remaining) {
+				return
+			}
+		}
+
+		summary["elapsed"] = milliseconds(time.Since(start))
+		json.NewEncoder(w).Encode(summary)
+	})
+}
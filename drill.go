@@ -0,0 +1,193 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// goawayDrill closes a fraction of connections with "Connection: close"
+// and watches whether the affected requests come back, matched by their
+// X-Request-Id, within the retry window.
+// HTTP/2 GOAWAY is not available since the server only speaks HTTP/1.x.
+type goawayDrill struct {
+	running int32
+
+	mu        sync.Mutex
+	fraction  float64
+	window    time.Duration
+	startedAt time.Time
+	endsAt    time.Time
+	closed    int
+	withoutID int
+	retried   int
+	late      int
+	pending   map[string]time.Time
+}
+
+var drill = &goawayDrill{}
+
+// goawayDrillReport is the JSON view of the current or last drill
+type goawayDrillReport struct {
+	Running           bool      `json:"running"`
+	Fraction          float64   `json:"fraction"`
+	Window            string    `json:"window"`
+	StartedAt         time.Time `json:"started_at"`
+	EndsAt            time.Time `json:"ends_at"`
+	ConnectionsClosed int       `json:"connections_closed"`
+	RequestsWithoutID int       `json:"requests_without_id"`
+	Retried           int       `json:"retried"`
+	RetriedLate       int       `json:"retried_late"`
+	NotRetried        int       `json:"not_retried"`
+	Pending           int       `json:"pending"`
+}
+
+func (d *goawayDrill) start(fraction float64, duration, window time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.fraction = fraction
+	d.window = window
+	d.startedAt = now
+	d.endsAt = now.Add(duration)
+	d.closed, d.withoutID, d.retried, d.late = 0, 0, 0, 0
+	d.pending = make(map[string]time.Time)
+	atomic.StoreInt32(&d.running, 1)
+}
+
+// observe is called for every request, it's a single atomic load while no
+// drill is running
+func (d *goawayDrill) observe(ctx *fasthttp.RequestCtx) {
+	if atomic.LoadInt32(&d.running) == 0 {
+		return
+	}
+
+	now := time.Now()
+	reqID := string(ctx.Request.Header.Peek(requestIDHeader))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if closedAt, ok := d.pending[reqID]; ok && reqID != "" {
+		delete(d.pending, reqID)
+		if now.Sub(closedAt) <= d.window {
+			d.retried++
+		} else {
+			d.late++
+		}
+		return
+	}
+
+	if now.After(d.endsAt) {
+		// Keep matching retries until the last window has passed
+		if now.After(d.endsAt.Add(d.window)) {
+			atomic.StoreInt32(&d.running, 0)
+		}
+		return
+	}
+
+	if !connSelected(ctx.ConnID(), d.fraction) {
+		return
+	}
+
+	ctx.SetConnectionClose()
+	d.closed++
+	if reqID == "" {
+		d.withoutID++
+		return
+	}
+	d.pending[reqID] = now
+}
+
+func (d *goawayDrill) report() *goawayDrillReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r := &goawayDrillReport{
+		Running:           atomic.LoadInt32(&d.running) == 1,
+		Fraction:          d.fraction,
+		Window:            d.window.String(),
+		StartedAt:         d.startedAt,
+		EndsAt:            d.endsAt,
+		ConnectionsClosed: d.closed,
+		RequestsWithoutID: d.withoutID,
+		Retried:           d.retried,
+		RetriedLate:       d.late,
+	}
+	now := time.Now()
+	for _, closedAt := range d.pending {
+		if now.Sub(closedAt) > d.window {
+			r.NotRetried++
+		} else {
+			r.Pending++
+		}
+	}
+	return r
+}
+
+// connSelected spreads connection IDs evenly so that roughly fraction of
+// them are picked
+func connSelected(connID uint64, fraction float64) bool {
+	return float64((connID*2654435761)%10000) < fraction*10000
+}
+
+// withDrill lets a running drill see every request before it's handled,
+// admin requests are left alone
+func withDrill(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !strings.HasPrefix(b2s(ctx.Path()), "/admin/") {
+			drill.observe(ctx)
+		}
+		h(ctx)
+	}
+}
+
+// drillHandler starts a drill on POST and reports on it on GET:
+// POST /admin/drill/goaway?fraction=0.1&duration=30s&window=5s
+func drillHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		writeJSON(ctx, fasthttp.StatusOK, drill.report())
+		return
+	}
+
+	args := ctx.QueryArgs()
+
+	fraction := 0.1
+	if args.Has("fraction") {
+		f, err := args.GetUfloat("fraction")
+		if err != nil || f > 1 {
+			ctx.Error("fraction must be between 0 and 1", fasthttp.StatusBadRequest)
+			return
+		}
+		fraction = f
+	}
+
+	duration, err := durationArg(args, "duration", 30*time.Second)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	window, err := durationArg(args, "window", 5*time.Second)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	drill.start(fraction, duration, window)
+	writeJSON(ctx, fasthttp.StatusAccepted, drill.report())
+}
+
+// durationArg parses a Go duration query argument, returning def when absent
+func durationArg(args *fasthttp.Args, key string, def time.Duration) (time.Duration, error) {
+	v := args.Peek(key)
+	if len(v) == 0 {
+		return def, nil
+	}
+	return time.ParseDuration(string(v))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Bounds of /drip, every byte is a write of its own
+const (
+	maxDripBytes   = 10 << 20
+	maxDripSeconds = 3600
+)
+
+var errInvalidSeconds = errors.New("invalid number of seconds")
+
+// dripHandler serves httpbin's /drip?numbytes=&duration=&delay=&code=. After
+// delay seconds numbytes asterisks are written evenly spread over duration
+// seconds, each byte written on its own.
+func dripHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+
+	numBytes, err := intArg(args, "numbytes", 10)
+	if err != nil || numBytes < 0 || numBytes > maxDripBytes {
+		ctx.Error("numbytes must be between 0 and "+strconv.Itoa(maxDripBytes), fasthttp.StatusBadRequest)
+		return
+	}
+	duration, err := secondsArg(args, "duration", 2)
+	if err != nil {
+		ctx.Error("duration must be a non-negative number of seconds", fasthttp.StatusBadRequest)
+		return
+	}
+	delay, err := secondsArg(args, "delay", 2)
+	if err != nil {
+		ctx.Error("delay must be a non-negative number of seconds", fasthttp.StatusBadRequest)
+		return
+	}
+	code, err := intArg(args, "code", fasthttp.StatusOK)
+	if err != nil || code < 200 || code > 599 {
+		ctx.Error("code must be a status code between 200 and 599", fasthttp.StatusBadRequest)
+		return
+	}
+
+	var interval time.Duration
+	if numBytes > 0 {
+		interval = duration / time.Duration(numBytes)
+	}
+
+	ctx.SetContentType("application/octet-stream")
+	ctx.SetStatusCode(code)
+	ctx.Response.Header.SetContentLength(numBytes)
+	ctx.Response.SetConnectionClose()
+	header := hijackHeader(ctx)
+
+	// fasthttp holds back the headers of small fixed-size bodies until the
+	// body is complete, so the connection is hijacked to send them first
+	// and closed once the last byte is written
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(c net.Conn) {
+		if _, err := c.Write(header); err != nil {
+			return
+		}
+		if !sleepStream(delay) {
+			return
+		}
+
+		next := time.Now()
+		for i := 0; i < numBytes; i++ {
+			// Sleep to absolute deadlines so slow writes don't stretch
+			// the total duration
+			if !sleepStream(time.Until(next)) {
+				return
+			}
+			next = next.Add(interval)
+
+			if _, err := c.Write([]byte{'*'}); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// intArg returns the integer query argument name or def when it's missing
+func intArg(args *fasthttp.Args, name string, def int) (int, error) {
+	v := args.Peek(name)
+	if len(v) == 0 {
+		return def, nil
+	}
+	return strconv.Atoi(b2s(v))
+}
+
+// secondsArg returns the query argument name given in (fractional) seconds
+// as httpbin does, or def seconds when it's missing
+func secondsArg(args *fasthttp.Args, name string, def float64) (time.Duration, error) {
+	seconds := def
+	if v := args.Peek(name); len(v) > 0 {
+		var err error
+		if seconds, err = strconv.ParseFloat(b2s(v), 64); err != nil {
+			return 0, err
+		}
+	}
+	if seconds < 0 || seconds > maxDripSeconds {
+		return 0, errInvalidSeconds
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/valyala/fasthttp"
+)
+
+// compress enables Accept-Encoding negotiation on generated responses
+var compress atomicBool
+
+// compressCodings are the codings -compress negotiates, preferred in this
+// order when the client gives them the same q-value
+var compressCodings = []string{"zstd", "br", "gzip", "deflate"}
+
+// maxCompressStream is the largest streamed body withCompress reads to
+// compress it, bigger and unsized streams are sent as they are
+const maxCompressStream = 1 << 20
+
+// zstdEncoder is only used through EncodeAll, which is safe for concurrent use
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// withCompress encodes response bodies with the coding the request's
+// Accept-Encoding rates highest while -compress is set. Responses already
+// encoded, partial, hijacked or streamed without a small known length are
+// left alone. The encoded body is a representation of its own: a strong
+// ETag is weakened, the identity body's digests and Accept-Ranges are
+// dropped.
+func withCompress(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		h(ctx)
+
+		if !compress.Get() || ctx.Hijacked() || ctx.IsHead() ||
+			len(ctx.Response.Header.Peek(fasthttp.HeaderContentEncoding)) > 0 {
+			return
+		}
+		switch status := ctx.Response.StatusCode(); {
+		case status < 200, status == fasthttp.StatusNoContent,
+			status == fasthttp.StatusPartialContent, status == fasthttp.StatusNotModified:
+			return
+		}
+		if ctx.Response.IsBodyStream() {
+			if n := ctx.Response.Header.ContentLength(); n < 0 || n > maxCompressStream {
+				return
+			}
+		}
+
+		coding := negotiateEncoding(b2s(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding)))
+		if coding == "" {
+			return
+		}
+		body := ctx.Response.Body()
+		if len(body) == 0 {
+			return
+		}
+
+		var encoded []byte
+		switch coding {
+		case "zstd":
+			encoded = zstdEncoder.EncodeAll(body, nil)
+		case "br":
+			encoded = fasthttp.AppendBrotliBytes(nil, body)
+		case "gzip":
+			encoded = fasthttp.AppendGzipBytes(nil, body)
+		case "deflate":
+			encoded = fasthttp.AppendDeflateBytes(nil, body)
+		}
+		ctx.SetBody(encoded)
+		header := &ctx.Response.Header
+		header.Set(fasthttp.HeaderContentEncoding, coding)
+		header.Add(fasthttp.HeaderVary, fasthttp.HeaderAcceptEncoding)
+		if etag := header.Peek(fasthttp.HeaderETag); len(etag) > 0 && !bytes.HasPrefix(etag, []byte("W/")) {
+			header.Set(fasthttp.HeaderETag, "W/"+string(etag))
+		}
+		header.Del(checksumHeader)
+		header.Del("Digest")
+		header.Del(fasthttp.HeaderAcceptRanges)
+	}
+}
+
+// negotiateEncoding picks the coding of compressCodings with the highest
+// q-value in accept, an explicit * covers the codings not listed. It's
+// empty when none is acceptable or identity is rated higher.
+func negotiateEncoding(accept string) string {
+	codings := parseAcceptEncoding(accept)
+	best, bestQ := "", 0.0
+	for _, coding := range compressCodings {
+		if q := codingQ(codings, coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	for _, c := range codings {
+		if strings.EqualFold(c.Coding, "identity") && c.Q > bestQ {
+			return ""
+		}
+	}
+	return best
+}
+
+// codingQ is the q-value accept gives coding, 0 when it's not accepted
+func codingQ(codings []acceptedCoding, coding string) float64 {
+	wildcard := 0.0
+	for _, c := range codings {
+		if strings.EqualFold(c.Coding, coding) {
+			return c.Q
+		}
+		if c.Coding == "*" {
+			wildcard = c.Q
+		}
+	}
+	return wildcard
+}
+
+// encodedJSON is the body of the fixed-encoding endpoints like /gzip
+type encodedJSON struct {
+	Gzipped  bool              `json:"gzipped,omitempty"`
+	Deflated bool              `json:"deflated,omitempty"`
+	Brotli   bool              `json:"brotli,omitempty"`
+	Zstd     bool              `json:"zstd,omitempty"`
+	Headers  map[string]string `json:"headers"`
+	Method   string            `json:"method"`
+	Origin   string            `json:"origin"`
+}
+
+// gzipHandler always responds with a gzip-encoded JSON body, regardless
+// of what the client accepts
+func gzipHandler(ctx *fasthttp.RequestCtx) {
+	resp := newEncodedJSON(ctx)
+	resp.Gzipped = true
+	writeEncodedJSON(ctx, "gzip", resp)
+}
+
+// deflateHandler always responds with a deflate-encoded JSON body
+func deflateHandler(ctx *fasthttp.RequestCtx) {
+	resp := newEncodedJSON(ctx)
+	resp.Deflated = true
+	writeEncodedJSON(ctx, "deflate", resp)
+}
+
+// brotliHandler always responds with a brotli-encoded JSON body
+func brotliHandler(ctx *fasthttp.RequestCtx) {
+	resp := newEncodedJSON(ctx)
+	resp.Brotli = true
+	writeEncodedJSON(ctx, "br", resp)
+}
+
+// zstdHandler always responds with a zstd-encoded JSON body
+func zstdHandler(ctx *fasthttp.RequestCtx) {
+	resp := newEncodedJSON(ctx)
+	resp.Zstd = true
+	writeEncodedJSON(ctx, "zstd", resp)
+}
+
+// writeEncodedJSON marshals v and writes it compressed with the given
+// content encoding
+func writeEncodedJSON(ctx *fasthttp.RequestCtx, encoding string, v interface{}) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	var body []byte
+	switch encoding {
+	case "gzip":
+		body = fasthttp.AppendGzipBytes(nil, jsonData)
+	case "deflate":
+		body = fasthttp.AppendDeflateBytes(nil, jsonData)
+	case "br":
+		body = fasthttp.AppendBrotliBytes(nil, jsonData)
+	case "zstd":
+		body = zstdEncoder.EncodeAll(jsonData, nil)
+	}
+
+	ctx.SetContentType("application/json")
+	ctx.Response.Header.Set(fasthttp.HeaderContentEncoding, encoding)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(body)
+}
+
+func newEncodedJSON(ctx *fasthttp.RequestCtx) *encodedJSON {
+	resp := &encodedJSON{
+		Headers: make(map[string]string),
+		Method:  string(ctx.Method()),
+		Origin:  ctx.RemoteIP().String(),
+	}
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		resp.Headers[string(k)] = string(v)
+	})
+	return resp
+}
+
+// acceptEncodingJSON describes the Accept-Encoding values of a request
+type acceptEncodingJSON struct {
+	Headers         []string         `json:"headers"`
+	Codings         []acceptedCoding `json:"codings"`
+	ContentEncoding string           `json:"content_encoding"`
+}
+
+type acceptedCoding struct {
+	Coding string  `json:"coding"`
+	Q      float64 `json:"q"`
+}
+
+// encodingHandler reports exactly which Accept-Encoding values arrived and
+// misbehaves on request with ?mode=:
+//
+//	identity  plain body with "Content-Encoding: identity"
+//	unknown   plain body labeled with an unknown coding (?token=, x-unknown by default)
+//	double    body gzipped twice but labeled with a single "gzip"
+func encodingHandler(ctx *fasthttp.RequestCtx) {
+	resp := &acceptEncodingJSON{Headers: []string{}, Codings: []acceptedCoding{}}
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		if strings.EqualFold(b2s(k), fasthttp.HeaderAcceptEncoding) {
+			resp.Headers = append(resp.Headers, string(v))
+			resp.Codings = append(resp.Codings, parseAcceptEncoding(string(v))...)
+		}
+	})
+
+	args := ctx.QueryArgs()
+	mode := string(args.Peek("mode"))
+	switch mode {
+	case "":
+	case "identity":
+		resp.ContentEncoding = "identity"
+	case "unknown":
+		resp.ContentEncoding = "x-unknown"
+		if token := args.Peek("token"); len(token) > 0 {
+			resp.ContentEncoding = string(token)
+		}
+	case "double":
+		resp.ContentEncoding = "gzip"
+	default:
+		ctx.Error("unknown mode "+mode, fasthttp.StatusBadRequest)
+		return
+	}
+
+	jsonData, err := json.Marshal(resp)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	if mode == "double" {
+		jsonData = fasthttp.AppendGzipBytes(nil, fasthttp.AppendGzipBytes(nil, jsonData))
+	}
+
+	ctx.SetContentType("application/json")
+	if resp.ContentEncoding != "" {
+		ctx.Response.Header.Set(fasthttp.HeaderContentEncoding, resp.ContentEncoding)
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(jsonData)
+}
+
+// parseAcceptEncoding splits an Accept-Encoding value into codings and
+// their q-values, q defaults to 1
+func parseAcceptEncoding(v string) []acceptedCoding {
+	var codings []acceptedCoding
+	for _, part := range strings.Split(v, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.TrimSpace(coding)
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		codings = append(codings, acceptedCoding{Coding: coding, Q: q})
+	}
+	return codings
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	eventsBufferSize        = 256
+	eventsHeartbeatInterval = 15 * time.Second
+)
+
+var eventsDropped = expvar.NewInt("events_dropped")
+
+// serverEvent is a single entry of the /admin/events stream
+type serverEvent struct {
+	Type   string                 `json:"type"`
+	Time   time.Time              `json:"time"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// eventBroker fans server events out to the /admin/events subscribers.
+// Slow subscribers lose events instead of blocking the publisher.
+type eventBroker struct {
+	mu     sync.Mutex
+	subs   map[chan serverEvent]struct{}
+	nsubs  int32
+	closed bool
+}
+
+var events = &eventBroker{subs: make(map[chan serverEvent]struct{})}
+
+func (b *eventBroker) subscribe() (chan serverEvent, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, false
+	}
+	ch := make(chan serverEvent, eventsBufferSize)
+	b.subs[ch] = struct{}{}
+	atomic.AddInt32(&b.nsubs, 1)
+	return ch, true
+}
+
+func (b *eventBroker) unsubscribe(ch chan serverEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		atomic.AddInt32(&b.nsubs, -1)
+		close(ch)
+	}
+}
+
+// active reports whether anybody is subscribed
+func (b *eventBroker) active() bool {
+	return atomic.LoadInt32(&b.nsubs) > 0
+}
+
+// publish sends an event to every subscriber, it's a no-op without subscribers
+func (b *eventBroker) publish(typ string, fields map[string]interface{}) {
+	if !b.active() {
+		return
+	}
+
+	ev := serverEvent{Type: typ, Time: time.Now(), Fields: fields}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			eventsDropped.Add(1)
+		}
+	}
+}
+
+// close ends all subscriptions so streaming responses don't hold up shutdown
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+	atomic.StoreInt32(&b.nsubs, 0)
+}
+
+// eventsHandler streams server events as Server-Sent Events
+func eventsHandler(ctx *fasthttp.RequestCtx) {
+	ch, ok := events.subscribe()
+	if !ok {
+		ctx.Error("server is shutting down", fasthttp.StatusServiceUnavailable)
+		return
+	}
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer events.unsubscribe(ch)
+
+		heartbeat := time.NewTicker(eventsHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		fmt.Fprint(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		for {
+			select {
+			case ev, ok := <-ch:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(ev)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+			case <-heartbeat.C:
+				// Comment lines keep proxies from timing the stream out and
+				// tell us when the client has gone away
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
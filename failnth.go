@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errInvalidFailRule = errors.New("expected [route=]every[:status], e.g. 10:503 or /bin=4:500")
+
+// failRule fails exactly one of every `every` requests it matches
+type failRule struct {
+	Route  string `json:"route"`
+	Every  int64  `json:"every"`
+	Status int    `json:"status"`
+	Seen   int64  `json:"seen"`
+	Failed int64  `json:"failed"`
+}
+
+// failRules holds the fail-every-Nth rules by route, the empty route
+// applies to every request. A flag.Value taking [route=]every[:status].
+type failRules struct {
+	mu    sync.RWMutex
+	rules map[string]*failRule
+}
+
+var failNth = &failRules{}
+
+func (f *failRules) String() string {
+	if f == nil {
+		return ""
+	}
+
+	var parts []string
+	for _, r := range f.list() {
+		part := strconv.FormatInt(r.Every, 10) + ":" + strconv.Itoa(r.Status)
+		if r.Route != "" {
+			part = r.Route + "=" + part
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set adds or replaces one rule, every=0 removes the rule of the route
+func (f *failRules) Set(value string) error {
+	route, spec, ok := strings.Cut(value, "=")
+	if !ok {
+		route, spec = "", value
+	}
+	everyStr, statusStr, hasStatus := strings.Cut(spec, ":")
+	every, err := strconv.ParseInt(everyStr, 10, 64)
+	if err != nil || every < 0 {
+		return errInvalidFailRule
+	}
+	status := fasthttp.StatusServiceUnavailable
+	if hasStatus {
+		if status, err = strconv.Atoi(statusStr); err != nil || status < 200 || status > 599 {
+			return errInvalidFailRule
+		}
+	}
+	// Requests are matched by their first path segment, see endpointLabel
+	if route != "" && (!strings.HasPrefix(route, "/") || strings.Contains(route[1:], "/")) {
+		return errInvalidFailRule
+	}
+
+	f.set(route, every, status)
+	return nil
+}
+
+func (f *failRules) set(route string, every int64, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if every == 0 {
+		delete(f.rules, route)
+		return
+	}
+	if f.rules == nil {
+		f.rules = make(map[string]*failRule)
+	}
+	f.rules[route] = &failRule{Route: route, Every: every, Status: status}
+}
+
+// list returns copies of the rules ordered by route
+func (f *failRules) list() []failRule {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	rules := make([]failRule, 0, len(f.rules))
+	for _, r := range f.rules {
+		rules = append(rules, failRule{
+			Route:  r.Route,
+			Every:  r.Every,
+			Status: r.Status,
+			Seen:   atomic.LoadInt64(&r.Seen),
+			Failed: atomic.LoadInt64(&r.Failed),
+		})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Route < rules[j].Route })
+	return rules
+}
+
+// match counts the request against the rule of its route, or the global
+// one, and returns the status to fail it with or 0
+func (f *failRules) match(path string) int {
+	f.mu.RLock()
+	r := f.rules[endpointLabel(path)]
+	if r == nil {
+		r = f.rules[""]
+	}
+	f.mu.RUnlock()
+
+	if r == nil {
+		return 0
+	}
+	if atomic.AddInt64(&r.Seen, 1)%r.Every != 0 {
+		return 0
+	}
+	atomic.AddInt64(&r.Failed, 1)
+	return r.Status
+}
+
+// withFailNth fails every Nth request of a route deterministically, so
+// circuit breakers can be checked against an exact error rate. The
+// watchdog's probes are neither counted nor failed.
+func withFailNth(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		path := b2s(ctx.Path())
+		if !strings.HasPrefix(path, "/admin/") && !isWatchdogRequest(ctx) {
+			if status := failNth.match(path); status != 0 {
+				ctx.Error(fmt.Sprintf("injected failure (%s)", fasthttp.StatusMessage(status)), status)
+				return
+			}
+		}
+		h(ctx)
+	}
+}
+
+// failNthHandler lists the rules on GET and sets one on POST:
+// POST /admin/fail-nth?route=/bin&every=10&status=503, every=0 removes it
+func failNthHandler(ctx *fasthttp.RequestCtx) {
+	if ctx.IsPost() {
+		args := ctx.QueryArgs()
+		value := string(args.Peek("every"))
+		if status := args.Peek("status"); len(status) > 0 {
+			value += ":" + string(status)
+		}
+		if route := args.Peek("route"); len(route) > 0 {
+			value = string(route) + "=" + value
+		}
+		if err := failNth.Set(value); err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, failNth.list())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// resetHandler serves /reset, which answers nothing and closes the
+// connection with a TCP reset instead of a FIN, for testing how clients
+// and proxies handle connection resets
+func resetHandler(ctx *fasthttp.RequestCtx) {
+	// The hijacked connection is wrapped, SO_LINGER needs the TCP one
+	raw := ctx.Conn()
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(net.Conn) {
+		logf(componentHTTP, "/reset %s", raw.RemoteAddr())
+		resetConn(raw)
+	})
+}
+
+// closeHandler serves /close?after=, which waits after (milliseconds or a
+// duration, none by default) and closes the connection without sending a
+// single byte of response, like an upstream crashing before it answers
+func closeHandler(ctx *fasthttp.RequestCtx) {
+	after, err := millisArg(ctx.QueryArgs(), "after")
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	raw := ctx.Conn()
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(net.Conn) {
+		// Draining or a gone client only close it sooner
+		sleepConn(raw, after)
+		logf(componentHTTP, "/close %s after %s", raw.RemoteAddr(), after.Round(time.Millisecond))
+	})
+}
+
+// malformedChunked is the body of /malformed/chunked by kind, each one a
+// valid chunk followed by the broken framing
+var malformedChunked = map[string]string{
+	"bad_size":      "5\r\nhello\r\nzz\r\nworld\r\n0\r\n\r\n",
+	"negative_size": "5\r\nhello\r\n-5\r\nworld\r\n0\r\n\r\n",
+	"overflow_size": "5\r\nhello\r\n10000000000000005\r\nworld\r\n0\r\n\r\n",
+	"short_chunk":   "5\r\nhello\r\n10\r\nworld\r\n0\r\n\r\n",
+	"long_chunk":    "5\r\nhello\r\n2\r\nworld\r\n0\r\n\r\n",
+	"missing_crlf":  "5\r\nhello\r\n5\r\nworld0\r\n\r\n",
+	"bare_lf":       "5\r\nhello\r\n5\nworld\n0\n\n",
+	"bad_extension": "5\r\nhello\r\n5;=\"bogus\r\nworld\r\n0\r\n\r\n",
+	"no_terminator": "5\r\nhello\r\n5\r\nworld\r\n",
+	"bad_trailer":   "5\r\nhello\r\n0\r\nno colon here\r\n\r\n",
+}
+
+// malformedChunkedHandler serves /malformed/chunked?kind=, a chunked
+// response whose framing is broken as kind says (bad_size by default):
+// bad_size, negative_size, overflow_size, short_chunk, long_chunk,
+// missing_crlf, bare_lf, bad_extension, no_terminator or bad_trailer. The
+// connection is closed right after, for testing the chunked parsers of
+// proxies.
+func malformedChunkedHandler(ctx *fasthttp.RequestCtx) {
+	kind := string(ctx.QueryArgs().Peek("kind"))
+	if kind == "" {
+		kind = "bad_size"
+	}
+	body, ok := malformedChunked[kind]
+	if !ok {
+		kinds := make([]string, 0, len(malformedChunked))
+		for k := range malformedChunked {
+			kinds = append(kinds, k)
+		}
+		sort.Strings(kinds)
+		ctx.Error("kind must be one of "+strings.Join(kinds, ", "), fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx.SetContentType("text/plain")
+	ctx.Response.Header.SetContentLength(-1)
+	ctx.Response.SetConnectionClose()
+	header := hijackHeader(ctx)
+
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(c net.Conn) {
+		c.Write(append(header, body...))
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// fixturesEICAR enables the EICAR anti-virus test file, off by default so
+// the server doesn't trip scanners in front of it unless asked to
+var fixturesEICAR bool
+
+// eicar is the standard anti-virus test string, split so this source file
+// isn't flagged itself
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$` + `EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fixture is a canonical test payload served at /fixtures/{name}
+type fixture struct {
+	contentType string
+	body        []byte
+}
+
+// fixtureIndexEntry is a single entry of /fixtures/index.json
+type fixtureIndexEntry struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	SHA256      string `json:"sha256"`
+}
+
+// fixtures are built once, their content never changes between releases
+// unless a fixture is added, so the published checksums are stable
+var fixtures = map[string]*fixture{
+	"valid.json":           {"application/json", []byte(sampleJSON)},
+	"invalid.json":         {"application/json", []byte(`{"slideshow": {"title": "Sample Slide Show", "slides": [{"title": "unterminated}` + "\n")},
+	"empty.json":           {"application/json", nil},
+	"huge-headers.http":    {"text/plain; charset=utf-8", hugeHeadersFixture()},
+	"utf8-torture.txt":     {"text/plain; charset=utf-8", utf8TortureFixture()},
+	"utf8-bom.txt":         {"text/plain; charset=utf-8", []byte("\xef\xbb\xbfBOM prefixed UTF-8 text\n")},
+	"eicar.com":            {"application/octet-stream", []byte(eicar)},
+	"sample.html":          {"text/html; charset=utf-8", []byte(sampleHTML)},
+	"sample.xml":           {"application/xml", []byte(sampleXML)},
+	"crlf-injection.txt":   {"text/plain; charset=utf-8", []byte("line one\r\nSet-Cookie: injected=1\r\n\r\nline two\n")},
+	"null-bytes.bin":       {"application/octet-stream", []byte("before\x00\x00\x00after\n")},
+	"deep-nesting.json":    {"application/json", []byte(strings.Repeat("[", 10000) + strings.Repeat("]", 10000))},
+	"long-line.txt":        {"text/plain; charset=utf-8", []byte(strings.Repeat("A", 1<<20) + "\n")},
+	"unicode-escapes.json": {"application/json", []byte(`{"escaped": "\u00e9\u4e2d\ud83d\ude00", "lone_surrogate": "\ud800"}` + "\n")},
+}
+
+// hugeHeadersFixture is a raw HTTP/1.1 request with 100 headers of 1 KiB
+// each, to be replayed against proxies' header size limits
+func hugeHeadersFixture() []byte {
+	var b strings.Builder
+	b.WriteString("GET / HTTP/1.1\r\nHost: example.com\r\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&b, "X-Large-%03d: %s\r\n", i, strings.Repeat(string(pattern[i%len(pattern)]), 1024))
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// utf8TortureFixture mixes valid multi-byte sequences with malformed ones
+// (overlong encodings, surrogates, truncated and stray continuation bytes)
+func utf8TortureFixture() []byte {
+	lines := []string{
+		"valid 2-byte: éßñ",
+		"valid 3-byte: 中文☃",
+		"valid 4-byte: \U0001f600\U0001f680",
+		"combining: e\u0301 a\u0308",
+		"zero width: a\u200bb\u200dc\ufeffd",
+		"rtl override: \u202eabc\u202c",
+		"overlong slash: \xc0\xaf \xe0\x80\xaf",
+		"surrogate: \xed\xa0\x80 \xed\xbf\xbf",
+		"truncated: \xe4\xb8 \xf0\x9f\x98",
+		"stray continuation: \x80 \xbf",
+		"invalid bytes: \xfe \xff",
+		"beyond U+10FFFF: \xf4\x90\x80\x80",
+		"noncharacters: \ufffe\uffff",
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func fixtureEnabled(name string) bool {
+	return name != "eicar.com" || fixturesEICAR
+}
+
+// fixturesHandler serves /fixtures/{name} and /fixtures/index.json with the
+// size and SHA-256 of every enabled fixture
+func fixturesHandler(ctx *fasthttp.RequestCtx) {
+	name := strings.TrimPrefix(b2s(ctx.Path()), "/fixtures/")
+
+	if name == "index.json" {
+		index := make([]*fixtureIndexEntry, 0, len(fixtures))
+		for name, f := range fixtures {
+			if !fixtureEnabled(name) {
+				continue
+			}
+			sum := sha256.Sum256(f.body)
+			index = append(index, &fixtureIndexEntry{
+				Name:        name,
+				ContentType: f.contentType,
+				Size:        len(f.body),
+				SHA256:      hex.EncodeToString(sum[:]),
+			})
+		}
+		sort.Slice(index, func(i, j int) bool { return index[i].Name < index[j].Name })
+		writeJSON(ctx, fasthttp.StatusOK, index)
+		return
+	}
+
+	f, ok := fixtures[name]
+	if !ok || !fixtureEnabled(name) {
+		ctx.Error("unknown fixture, see /fixtures/index.json", fasthttp.StatusNotFound)
+		return
+	}
+
+	sum := sha256.Sum256(f.body)
+	ctx.SetContentType(f.contentType)
+	ctx.Response.Header.Set(fasthttp.HeaderETag, `"`+hex.EncodeToString(sum[:])+`"`)
+	ctx.SetBody(f.body)
+}
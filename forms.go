@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// formPage is an httpbin-like order form, posted back to /forms/post
+const formPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Form test</title>
+  </head>
+  <body>
+    <form method="post" action="/forms/post" enctype="%ENCTYPE%">
+      <p><label>Customer name: <input name="custname"></label></p>
+      <p><label>Telephone: <input type=tel name="custtel"></label></p>
+      <p><label>E-mail address: <input type=email name="custemail"></label></p>
+      <fieldset>
+        <legend> Pizza Size </legend>
+        <p><label> <input type=radio name=size value="small"> Small </label></p>
+        <p><label> <input type=radio name=size value="medium"> Medium </label></p>
+        <p><label> <input type=radio name=size value="large"> Large </label></p>
+      </fieldset>
+      <fieldset>
+        <legend> Pizza Toppings </legend>
+        <p><label> <input type=checkbox name="topping" value="bacon"> Bacon </label></p>
+        <p><label> <input type=checkbox name="topping" value="cheese"> Extra Cheese </label></p>
+        <p><label> <input type=checkbox name="topping" value="onion"> Onion </label></p>
+        <p><label> <input type=checkbox name="topping" value="mushroom"> Mushroom </label></p>
+      </fieldset>
+      <p><label>Preferred delivery time: <input type=time min="11:00" max="21:00" step="900" name="delivery"></label></p>
+      <p><label>Delivery instructions: <textarea name="comments"></textarea></label></p>
+      <p><label>Attachment: <input type=file name="attachment" multiple></label></p>
+      <p><button>Submit order</button></p>
+    </form>
+  </body>
+</html>
+`
+
+// formFile describes an uploaded file without echoing its content
+type formFile struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// formsHandler serves the test form on GET, multipart encoded with
+// ?enctype=multipart, and echoes submitted fields and file metadata as
+// JSON on POST
+func formsHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() {
+		enctype := "application/x-www-form-urlencoded"
+		if string(ctx.QueryArgs().Peek("enctype")) == "multipart" {
+			enctype = "multipart/form-data"
+		}
+		ctx.SetContentType("text/html; charset=utf-8")
+		ctx.SetBodyString(strings.Replace(formPage, "%ENCTYPE%", enctype, 1))
+		return
+	}
+
+	form := map[string]interface{}{}
+	files := map[string][]*formFile{}
+
+	contentType := b2s(ctx.Request.Header.ContentType())
+	switch {
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		mf, err := ctx.MultipartForm()
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		for name, values := range mf.Value {
+			form[name] = collapse(values)
+		}
+		for name, headers := range mf.File {
+			for _, fh := range headers {
+				files[name] = append(files[name], &formFile{
+					Filename:    fh.Filename,
+					ContentType: fh.Header.Get("Content-Type"),
+					Size:        fh.Size,
+				})
+			}
+		}
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		form = argsToMap(ctx.PostArgs())
+	default:
+		ctx.Error("expected an urlencoded or multipart form", fasthttp.StatusUnsupportedMediaType)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, map[string]interface{}{
+		"content_type": contentType,
+		"form":         form,
+		"files":        files,
+	})
+}
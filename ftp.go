@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ftpDataTimeout = 30 * time.Second
+
+// ftpAddr enables the FTP byte source when set
+var ftpAddr string
+
+// serveFTP runs a minimal passive-mode FTP server where every file name is
+// a size ("10M", "/1G") and retrieving it yields the /bin pattern. Any
+// user and password are accepted.
+func serveFTP(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logf(componentFTP, "accept error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go (&ftpConn{ctrl: c}).serve()
+	}
+}
+
+type ftpConn struct {
+	ctrl   net.Conn
+	w      *bufio.Writer
+	pasv   net.Listener
+	offset int64
+}
+
+func (c *ftpConn) reply(code int, format string, args ...interface{}) error {
+	fmt.Fprintf(c.w, "%d %s\r\n", code, fmt.Sprintf(format, args...))
+	return c.w.Flush()
+}
+
+func (c *ftpConn) serve() {
+	defer c.ctrl.Close()
+	defer c.closePasv()
+
+	c.w = bufio.NewWriter(c.ctrl)
+	r := bufio.NewReader(c.ctrl)
+
+	if err := c.reply(220, "hpdummy FTP ready"); err != nil {
+		return
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		if err := c.handle(strings.ToUpper(cmd), arg); err != nil {
+			return
+		}
+	}
+}
+
+func (c *ftpConn) handle(cmd, arg string) error {
+	switch cmd {
+	case "USER":
+		return c.reply(331, "any password will do")
+	case "PASS":
+		return c.reply(230, "logged in")
+	case "SYST":
+		return c.reply(215, "UNIX Type: L8")
+	case "FEAT":
+		fmt.Fprint(c.w, "211-Features:\r\n EPSV\r\n PASV\r\n SIZE\r\n REST STREAM\r\n")
+		return c.reply(211, "End")
+	case "TYPE", "MODE", "STRU":
+		return c.reply(200, "ok")
+	case "PWD":
+		return c.reply(257, `"/" is the current directory`)
+	case "CWD", "CDUP":
+		return c.reply(250, "ok")
+	case "NOOP":
+		return c.reply(200, "ok")
+	case "PASV", "EPSV":
+		return c.passive(cmd)
+	case "SIZE":
+		size, err := ftpFileSize(arg)
+		if err != nil {
+			return c.reply(550, "file names are sizes like 10M")
+		}
+		return c.reply(213, "%d", size)
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			return c.reply(501, "invalid offset")
+		}
+		c.offset = offset
+		return c.reply(350, "restarting at %d", offset)
+	case "RETR":
+		return c.retr(arg)
+	case "LIST", "NLST":
+		return c.list(cmd == "NLST")
+	case "QUIT":
+		c.reply(221, "bye")
+		return io.EOF
+	default:
+		return c.reply(502, "%s not implemented", cmd)
+	}
+}
+
+func (c *ftpConn) passive(cmd string) error {
+	c.closePasv()
+
+	host, _, _ := net.SplitHostPort(c.ctrl.LocalAddr().String())
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return c.reply(425, "can't open data connection")
+	}
+	c.pasv = ln
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	if cmd == "EPSV" {
+		return c.reply(229, "Entering Extended Passive Mode (|||%d|)", port)
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		return c.reply(522, "use EPSV for IPv6")
+	}
+	return c.reply(227, "Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff)
+}
+
+func (c *ftpConn) closePasv() {
+	if c.pasv != nil {
+		c.pasv.Close()
+		c.pasv = nil
+	}
+}
+
+// dataConn accepts the client's data connection on the passive listener
+func (c *ftpConn) dataConn() (net.Conn, error) {
+	if c.pasv == nil {
+		return nil, fmt.Errorf("no passive listener")
+	}
+	defer c.closePasv()
+
+	if tl, ok := c.pasv.(*net.TCPListener); ok {
+		tl.SetDeadline(time.Now().Add(ftpDataTimeout))
+	}
+	return c.pasv.Accept()
+}
+
+func (c *ftpConn) retr(name string) error {
+	offset := c.offset
+	c.offset = 0
+
+	size, err := ftpFileSize(name)
+	if err != nil {
+		return c.reply(550, "file names are sizes like 10M")
+	}
+	if offset > size {
+		offset = size
+	}
+
+	if err := c.reply(150, "sending %d bytes", size-offset); err != nil {
+		return err
+	}
+	dc, err := c.dataConn()
+	if err != nil {
+		return c.reply(425, "can't open data connection")
+	}
+
+	n, err := io.Copy(dc, newPatternReader(offset, size-offset))
+	dc.Close()
+	if err != nil {
+		logf(componentFTP, "RETR %s aborted after %d bytes: %v", name, n, err)
+		return c.reply(426, "transfer aborted")
+	}
+	return c.reply(226, "transfer complete")
+}
+
+// ftpListing is what LIST shows, any other size can be retrieved too
+var ftpListing = []string{"1K", "64K", "1M", "10M", "100M", "1G"}
+
+func (c *ftpConn) list(namesOnly bool) error {
+	if err := c.reply(150, "listing"); err != nil {
+		return err
+	}
+	dc, err := c.dataConn()
+	if err != nil {
+		return c.reply(425, "can't open data connection")
+	}
+
+	w := bufio.NewWriter(dc)
+	modTime := startTime.Format("Jan _2 15:04")
+	for _, name := range ftpListing {
+		if namesOnly {
+			fmt.Fprintf(w, "%s\r\n", name)
+			continue
+		}
+		size, _ := parseSize(name)
+		fmt.Fprintf(w, "-r--r--r-- 1 hpdummy hpdummy %d %s %s\r\n", size, modTime, name)
+	}
+	w.Flush()
+	dc.Close()
+
+	return c.reply(226, "listing complete")
+}
+
+func ftpFileSize(name string) (int64, error) {
+	name = strings.TrimSpace(name)
+	return parseSize(name[strings.LastIndexByte(name, '/')+1:])
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	delayHeader        = "X-HPDummy-Delay"
+	appliedDelayHeader = "X-HPDummy-Delay-Applied"
+
+	// appliedDelayKey is the user value holding the delay applied to a request
+	appliedDelayKey = "hpdummy_applied_delay"
+)
+
+// withHeaderDelay sleeps X-HPDummy-Delay milliseconds before handling any
+// request so load generators can vary latency without changing URLs. The
+// applied delay is returned in X-HPDummy-Delay-Applied and in echo JSON.
+func withHeaderDelay(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		v := ctx.Request.Header.Peek(delayHeader)
+		if len(v) == 0 {
+			h(ctx)
+			return
+		}
+
+		ms, err := strconv.ParseFloat(b2s(v), 64)
+		delay := time.Duration(ms * float64(time.Millisecond))
+		if err != nil || delay < 0 || delay > maxDelay {
+			ctx.Error(delayHeader+" must be milliseconds up to "+strconv.FormatInt(maxDelay.Milliseconds(), 10), fasthttp.StatusBadRequest)
+			return
+		}
+
+		if !sleepRequest(ctx, delay) {
+			return
+		}
+		ctx.SetUserValue(appliedDelayKey, delay)
+
+		h(ctx)
+
+		ctx.Response.Header.Set(appliedDelayHeader, strconv.FormatFloat(ms, 'f', -1, 64))
+	}
+}
+
+// appliedDelay returns the delay withHeaderDelay applied to the request
+func appliedDelay(ctx *fasthttp.RequestCtx) time.Duration {
+	d, _ := ctx.UserValue(appliedDelayKey).(time.Duration)
+	return d
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/valyala/fasthttp"
+)
+
+// responseHeadersHandler sets every query argument as a response header,
+// repeated keys become repeated headers, and reports them as JSON.
+// Headers are applied after the body so they can override Content-Type.
+// Keys that aren't tokens and values with control characters are refused
+// with 400, they would split the response.
+func responseHeadersHandler(ctx *fasthttp.RequestCtx) {
+	valid := true
+	ctx.QueryArgs().VisitAll(func(k, v []byte) {
+		valid = valid && isToken(b2s(k)) && validHeaderValue(v)
+	})
+	if !valid {
+		ctx.Error("header names must be tokens and values free of control characters", fasthttp.StatusBadRequest)
+		return
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, argsToMap(ctx.QueryArgs()))
+
+	ctx.QueryArgs().VisitAll(func(k, v []byte) {
+		ctx.Response.Header.AddBytesKV(k, v)
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Geometry of the rendered heatmap in pixels
+const (
+	heatmapWidth      = 720
+	heatmapCellHeight = 14
+	heatmapMarginLeft = 70
+	heatmapMarginTop  = 30
+	heatmapSparkline  = 40
+)
+
+// heatmapHandler serves /stats/heatmap.svg?endpoint=&window=, the latency
+// histogram of the last window (10m by default) as an SVG heatmap with a
+// request rate sparkline. Without endpoint all endpoints are merged.
+func heatmapHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+
+	window := 10 * time.Minute
+	if w := args.Peek("window"); len(w) > 0 {
+		d, err := time.ParseDuration(b2s(w))
+		if err != nil || d < latencySlot || d > latencySlots*latencySlot {
+			ctx.Error(fmt.Sprintf("window must be a duration between %s and %s", latencySlot, latencySlots*latencySlot), fasthttp.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	n := int(window / latencySlot)
+
+	endpoint := string(args.Peek("endpoint"))
+	now := time.Now()
+
+	var slots [][latencyBuckets]uint32
+	if endpoint == "" {
+		slots = make([][latencyBuckets]uint32, n)
+		for _, name := range latencyEndpoints() {
+			for k, s := range latencyHistogramOf(name).snapshot(now, n) {
+				for b, c := range s {
+					slots[k][b] += c
+				}
+			}
+		}
+	} else {
+		h := latencyHistogramOf(endpoint)
+		if h == nil {
+			ctx.Error("unknown endpoint, known: "+fmt.Sprint(latencyEndpoints()), fasthttp.StatusNotFound)
+			return
+		}
+		slots = h.snapshot(now, n)
+	}
+
+	title := endpoint
+	if title == "" {
+		title = "all endpoints"
+	}
+	title = fmt.Sprintf("%s, last %s", title, window)
+
+	ctx.SetContentType("image/svg+xml")
+	ctx.Response.Header.Set(fasthttp.HeaderCacheControl, "no-store")
+	ctx.SetBody(renderHeatmap(title, slots))
+}
+
+// renderHeatmap draws one column per slot and one row per latency bucket,
+// limited to the buckets that have data. Cell shade is log scaled to the
+// busiest cell.
+func renderHeatmap(title string, slots [][latencyBuckets]uint32) []byte {
+	lo, hi := latencyBuckets, -1
+	var maxCount uint32
+	totals := make([]uint64, len(slots))
+	var maxTotal uint64
+	for k, s := range slots {
+		for b, c := range s {
+			if c == 0 {
+				continue
+			}
+			if b < lo {
+				lo = b
+			}
+			if b > hi {
+				hi = b
+			}
+			if c > maxCount {
+				maxCount = c
+			}
+			totals[k] += uint64(c)
+		}
+		if totals[k] > maxTotal {
+			maxTotal = totals[k]
+		}
+	}
+	if hi < 0 {
+		lo, hi = 0, 0
+	}
+
+	rows := hi - lo + 1
+	cellWidth := float64(heatmapWidth) / float64(len(slots))
+	mapHeight := rows * heatmapCellHeight
+	sparkTop := heatmapMarginTop + mapHeight + 10
+	width := heatmapMarginLeft + heatmapWidth + 10
+	height := sparkTop + heatmapSparkline + 20
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="10">`+"\n", width, height)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", width, height)
+	fmt.Fprintf(&buf, `<text x="%d" y="18" font-size="12">%s</text>`+"\n", heatmapMarginLeft, html.EscapeString(title))
+
+	// Rows go from the slowest bucket at the top to the fastest at the bottom
+	for b := hi; b >= lo; b-- {
+		y := heatmapMarginTop + (hi-b)*heatmapCellHeight
+		fmt.Fprintf(&buf, `<text x="%d" y="%d" text-anchor="end">%s</text>`+"\n",
+			heatmapMarginLeft-4, y+heatmapCellHeight-3, latencyBucketLabel(b))
+		for k, s := range slots {
+			if s[b] == 0 {
+				continue
+			}
+			shade := math.Log1p(float64(s[b])) / math.Log1p(float64(maxCount))
+			fmt.Fprintf(&buf, `<rect x="%.1f" y="%d" width="%.1f" height="%d" fill="rgb(%d,%d,%d)"><title>%d</title></rect>`+"\n",
+				heatmapMarginLeft+float64(k)*cellWidth, y, cellWidth+0.5, heatmapCellHeight,
+				255-int(shade*55), 235-int(shade*200), 200-int(shade*190), s[b])
+		}
+	}
+	fmt.Fprintf(&buf, `<rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="#999"/>`+"\n",
+		heatmapMarginLeft, heatmapMarginTop, heatmapWidth, mapHeight)
+
+	// Sparkline of requests per slot below the heatmap
+	fmt.Fprintf(&buf, `<text x="%d" y="%d" text-anchor="end">req/%s</text>`+"\n",
+		heatmapMarginLeft-4, sparkTop+heatmapSparkline/2, latencySlot)
+	buf.WriteString(`<polyline fill="none" stroke="#c33" points="`)
+	for k, t := range totals {
+		y := float64(sparkTop + heatmapSparkline)
+		if maxTotal > 0 {
+			y -= float64(t) / float64(maxTotal) * heatmapSparkline
+		}
+		fmt.Fprintf(&buf, "%.1f,%.1f ", heatmapMarginLeft+(float64(k)+0.5)*cellWidth, y)
+	}
+	buf.WriteString(`"/>` + "\n")
+	fmt.Fprintf(&buf, `<text x="%d" y="%d">peak %d</text>`+"\n",
+		heatmapMarginLeft, sparkTop+heatmapSparkline+14, maxTotal)
+
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Commands run at lifecycle points, empty ones are skipped
+var (
+	onStart     string
+	onDrain     string
+	onShutdown  string
+	hookTimeout time.Duration
+)
+
+// runHook runs command through the shell and waits for it, killing it once
+// hookTimeout passes. The lifecycle point and listen address are passed in
+// HPDUMMY_HOOK and HPDUMMY_ADDR.
+func runHook(name, command, addr string) {
+	if command == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), "HPDUMMY_HOOK="+name, "HPDUMMY_ADDR="+addr)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = ctx.Err()
+	}
+	if err != nil {
+		logf(componentHooks, "%s hook failed after %v: %v", name, time.Since(start), err)
+	}
+	events.publish("hook", map[string]interface{}{
+		"name":     name,
+		"duration": time.Since(start).String(),
+		"ok":       err == nil,
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"expvar"
+	"net"
+	"sync"
+	"time"
+)
+
+// idleRST is how long a keep-alive connection may sit idle before it's
+// reset, 0 disables it
+var idleRST time.Duration
+
+var idleRSTKilled = expvar.NewInt("idle_rst_killed")
+
+// idleTimers holds the pending reset of every idle connection
+var (
+	idleTimersMu sync.Mutex
+	idleTimers   = make(map[net.Conn]*time.Timer)
+)
+
+// lingerer is implemented by TCP connections
+type lingerer interface {
+	SetLinger(sec int) error
+}
+
+// armIdleRST schedules c to be reset once it has been idle for idleRST.
+// A zero linger makes the kernel send RST instead of FIN on close, like
+// appliance origins that silently drop their connection state.
+func armIdleRST(c net.Conn) {
+	if idleRST <= 0 {
+		return
+	}
+
+	idleTimersMu.Lock()
+	defer idleTimersMu.Unlock()
+
+	if t, ok := idleTimers[c]; ok {
+		t.Stop()
+	}
+	idleTimers[c] = time.AfterFunc(idleRST, func() {
+		idleTimersMu.Lock()
+		_, pending := idleTimers[c]
+		delete(idleTimers, c)
+		idleTimersMu.Unlock()
+		if !pending {
+			return
+		}
+
+		resetConn(c)
+		idleRSTKilled.Add(1)
+		publishConnEvent("conn_idle_rst", c)
+	})
+}
+
+// resetConn closes c with RST instead of FIN where the connection allows
+func resetConn(c net.Conn) {
+	if l, ok := c.(lingerer); ok {
+		l.SetLinger(0)
+	}
+	c.Close()
+}
+
+// disarmIdleRST cancels the pending reset of c when it becomes active or
+// is closed
+func disarmIdleRST(c net.Conn) {
+	if idleRST <= 0 {
+		return
+	}
+
+	idleTimersMu.Lock()
+	defer idleTimersMu.Unlock()
+
+	if t, ok := idleTimers[c]; ok {
+		t.Stop()
+		delete(idleTimers, c)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+const maxImageSide = 4096
+
+// webpImage is a 1x1 lossless WebP. The standard library has no WebP
+// encoder, so unlike the other formats it's not generated and ignores
+// width and height.
+var webpImage = []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00")
+
+// gradientImage draws a diagonal color gradient, smooth enough for lossy
+// encoders and image optimizers to have something to work on
+func gradientImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{
+				R: uint8(255 * x / width),
+				G: uint8(255 * y / height),
+				B: uint8(255 * (x + y) / (width + height)),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// imageHandler serves /image/{png,jpeg,webp,svg}?width=&height=, a
+// generated 256x256 image unless sized otherwise
+func imageHandler(ctx *fasthttp.RequestCtx) {
+	format := strings.TrimPrefix(b2s(ctx.Path()), "/image/")
+
+	args := ctx.QueryArgs()
+	width, err := intArg(args, "width", 256)
+	if err != nil || width < 1 || width > maxImageSide {
+		ctx.Error("width must be between 1 and "+strconv.Itoa(maxImageSide), fasthttp.StatusBadRequest)
+		return
+	}
+	height, err := intArg(args, "height", 256)
+	if err != nil || height < 1 || height > maxImageSide {
+		ctx.Error("height must be between 1 and "+strconv.Itoa(maxImageSide), fasthttp.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "png":
+		err = png.Encode(&buf, gradientImage(width, height))
+	case "jpeg", "jpg":
+		format = "jpeg"
+		err = jpeg.Encode(&buf, gradientImage(width, height), &jpeg.Options{Quality: 90})
+	case "webp":
+		buf.Write(webpImage)
+	case "svg":
+		format = "svg+xml"
+		fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+		buf.WriteString(`<defs><linearGradient id="g" x1="0" y1="0" x2="1" y2="1"><stop offset="0" stop-color="#000080"/><stop offset="1" stop-color="#ffff80"/></linearGradient></defs>` + "\n")
+		fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="url(#g)"/>`+"\n", width, height)
+		fmt.Fprintf(&buf, `<text x="50%%" y="50%%" text-anchor="middle" dominant-baseline="middle" font-family="sans-serif" fill="#fff">%dx%d</text>`+"\n", width, height)
+		buf.WriteString("</svg>\n")
+	default:
+		ctx.Error("expected /image/png, /image/jpeg, /image/webp or /image/svg", fasthttp.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.SetContentType("image/" + format)
+	ctx.SetBody(buf.Bytes())
+}
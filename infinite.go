@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"expvar"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	infiniteStreamsOpen  = expvar.NewInt("infinite_streams_open")
+	infiniteStreamsTotal = expvar.NewInt("infinite_streams_total")
+	infiniteBytesSent    = expvar.NewInt("infinite_bytes_sent")
+)
+
+// infiniteChunk is whole repetitions of the /bin pattern, so writing it
+// over and over continues the pattern seamlessly
+var infiniteChunk = []byte(strings.Repeat(pattern, 32<<10/len(pattern)))
+
+// infiniteHandler serves /bin/infinite and /chunked/infinite, the /bin
+// pattern streamed chunked until the client goes away or the server starts
+// draining. The bytes sent are counted in infinite_bytes_sent and logged
+// when the stream ends.
+func infiniteHandler(ctx *fasthttp.RequestCtx) {
+	headerDelay, bodyDelay, err := phaseDelays(ctx)
+	if err == nil {
+		err = parseAbortAfter(ctx)
+	}
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if !sleepRequest(ctx, headerDelay) {
+		return
+	}
+
+	ctx.SetContentType("application/octet-stream")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	if ctx.IsHead() {
+		ctx.Response.SkipBody = true
+		return
+	}
+
+	// The request is not available anymore once the body is streamed
+	remote := ctx.RemoteAddr().String()
+	path := string(ctx.Path())
+
+	setBodyStreamWriter(ctx, bodyDelay, func(w *bufio.Writer) {
+		infiniteStreamsOpen.Add(1)
+		infiniteStreamsTotal.Add(1)
+		start := time.Now()
+		var sent int64
+		reason := "client gone"
+		defer func() {
+			infiniteStreamsOpen.Add(-1)
+			logf(componentHTTP, "%s to %s ended after %d bytes in %s: %s",
+				path, remote, sent, time.Since(start).Round(time.Millisecond), reason)
+		}()
+
+		for {
+			select {
+			case <-draining:
+				reason = "draining"
+				return
+			default:
+			}
+
+			n, err := w.Write(infiniteChunk)
+			sent += int64(n)
+			infiniteBytesSent.Add(int64(n))
+			if err != nil {
+				return
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// continueDelay is waited before answering Expect: 100-continue, unless
+// the request sets ?continue_delay=
+var continueDelay time.Duration
+
+// defaultEarlyHints are the Link headers of /early-hints without ?link=
+var defaultEarlyHints = []string{
+	"</style.css>; rel=preload; as=style",
+	"</script.js>; rel=preload; as=script",
+}
+
+// continueHandler decides on Expect: 100-continue before the body is read.
+// It waits continueDelay or ?continue_delay= and rejects the request with
+// 417 when ?continue=false, ?continue=417 or ?continue=413. fasthttp can
+// only reject with 417 here, the 413 of ?continue=413 is left to /upload
+// for requests without Expect.
+func continueHandler(header *fasthttp.RequestHeader) bool {
+	var args fasthttp.Args
+	uri := header.RequestURI()
+	if i := bytes.IndexByte(uri, '?'); i >= 0 {
+		args.ParseBytes(uri[i+1:])
+	}
+
+	delay := continueDelay
+	if v := args.Peek("continue_delay"); len(v) > 0 {
+		if d, err := parseMillis(b2s(v)); err == nil {
+			delay = d
+		}
+	}
+	sleepStream(delay)
+
+	switch v := string(args.Peek("continue")); v {
+	case "":
+		return true
+	case "413", "417":
+		return false
+	default:
+		return args.GetBool("continue")
+	}
+}
+
+// earlyHintsHandler serves /early-hints?link=&delay=, a 103 Early Hints
+// interim response with a Link header per ?link= (two preloads by default)
+// followed after delay by the final 200. fasthttp can't send interim
+// responses, so the connection is hijacked and closed afterwards.
+func earlyHintsHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+
+	delay, err := millisArg(args, "delay")
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	links := defaultEarlyHints
+	if peeked := args.PeekMulti("link"); len(peeked) > 0 {
+		links = make([]string, len(peeked))
+		for i, l := range peeked {
+			// Control characters would end up as headers of their own
+			if !validHeaderValue(l) {
+				ctx.Error("link must be free of control characters", fasthttp.StatusBadRequest)
+				return
+			}
+			links[i] = string(l)
+		}
+	}
+
+	var hints bytes.Buffer
+	hints.WriteString("HTTP/1.1 103 Early Hints\r\n")
+	for _, l := range links {
+		fmt.Fprintf(&hints, "Link: %s\r\n", l)
+	}
+	hints.WriteString("\r\n")
+
+	body := "<!DOCTYPE html>\n<html><head>\n"
+	for _, l := range links {
+		body += fmt.Sprintf("<!-- %s -->\n", l)
+	}
+	body += "</head><body>early hints</body></html>\n"
+
+	ctx.SetContentType("text/html; charset=utf-8")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	for _, l := range links {
+		ctx.Response.Header.Add("Link", l)
+	}
+	ctx.Response.Header.SetContentLength(len(body))
+	ctx.Response.SetConnectionClose()
+	final := hijackHeader(ctx)
+	final = append(final, body...)
+
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(c net.Conn) {
+		if _, err := c.Write(hints.Bytes()); err != nil {
+			return
+		}
+		if !sleepStream(delay) {
+			return
+		}
+		c.Write(final)
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import "sync"
+
+// kvStore is a small in-memory key/value store shared by the protocol sinks
+type kvStore struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+var kv = &kvStore{data: make(map[string][]byte)}
+
+func (s *kvStore) get(key string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func (s *kvStore) set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[key] = value
+}
+
+// del removes the keys and returns how many of them existed
+func (s *kvStore) del(keys ...string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, key := range keys {
+		if _, ok := s.data[key]; ok {
+			delete(s.data, key)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *kvStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.data)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// latencySlot is the time resolution of the recorded histograms, the
+	// last latencySlots slots are kept
+	latencySlot  = 10 * time.Second
+	latencySlots = 360
+
+	// Bucket i counts latencies in [2^i, 2^(i+1)) microseconds, the last
+	// bucket is open-ended (~8.4s and above)
+	latencyBuckets = 24
+
+	// maxLatencyEndpoints bounds the number of endpoints tracked separately,
+	// the rest are recorded as "other"
+	maxLatencyEndpoints = 64
+)
+
+// latencyHistogram is a ring of per-slot latency histograms of one endpoint
+type latencyHistogram struct {
+	mu      sync.Mutex
+	slotIDs [latencySlots]int64
+	counts  [latencySlots][latencyBuckets]uint32
+}
+
+func (h *latencyHistogram) record(now time.Time, d time.Duration) {
+	id := now.UnixNano() / int64(latencySlot)
+	i := id % latencySlots
+
+	if atomic.LoadInt64(&h.slotIDs[i]) != id {
+		h.mu.Lock()
+		if h.slotIDs[i] != id {
+			for b := range h.counts[i] {
+				atomic.StoreUint32(&h.counts[i][b], 0)
+			}
+			atomic.StoreInt64(&h.slotIDs[i], id)
+		}
+		h.mu.Unlock()
+	}
+
+	atomic.AddUint32(&h.counts[i][latencyBucket(d)], 1)
+}
+
+// snapshot returns the histograms of the last n slots up to now, oldest
+// first, slots without data are all zeros
+func (h *latencyHistogram) snapshot(now time.Time, n int) [][latencyBuckets]uint32 {
+	last := now.UnixNano() / int64(latencySlot)
+
+	out := make([][latencyBuckets]uint32, n)
+	for k := 0; k < n; k++ {
+		id := last - int64(n-1-k)
+		i := id % latencySlots
+		if atomic.LoadInt64(&h.slotIDs[i]) != id {
+			continue
+		}
+		for b := range out[k] {
+			out[k][b] = atomic.LoadUint32(&h.counts[i][b])
+		}
+	}
+	return out
+}
+
+func latencyBucket(d time.Duration) int {
+	us := d.Microseconds()
+	b := 0
+	for us > 1 && b < latencyBuckets-1 {
+		us >>= 1
+		b++
+	}
+	return b
+}
+
+// latencyBucketLabel returns the lower bound of bucket b for display
+func latencyBucketLabel(b int) string {
+	d := time.Duration(1<<uint(b)) * time.Microsecond
+	if b == 0 {
+		d = 0
+	}
+	return d.String()
+}
+
+var (
+	latenciesMu sync.RWMutex
+	latencies   = make(map[string]*latencyHistogram)
+)
+
+// recordLatency adds a handled request to the histogram of its endpoint
+func recordLatency(path string, now time.Time, d time.Duration) {
+	endpoint := endpointLabel(path)
+
+	latenciesMu.RLock()
+	h := latencies[endpoint]
+	latenciesMu.RUnlock()
+
+	if h == nil {
+		latenciesMu.Lock()
+		if len(latencies) >= maxLatencyEndpoints {
+			endpoint = "other"
+		}
+		if h = latencies[endpoint]; h == nil {
+			h = &latencyHistogram{}
+			latencies[endpoint] = h
+		}
+		latenciesMu.Unlock()
+	}
+
+	h.record(now, d)
+}
+
+// latencyEndpoints returns the tracked endpoints in name order
+func latencyEndpoints() []string {
+	latenciesMu.RLock()
+	defer latenciesMu.RUnlock()
+
+	names := make([]string, 0, len(latencies))
+	for name := range latencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func latencyHistogramOf(endpoint string) *latencyHistogram {
+	latenciesMu.RLock()
+	defer latenciesMu.RUnlock()
+
+	return latencies[endpoint]
+}
+
+// endpointLabel reduces a path to its first segment so that parameters
+// like /bin/{size} don't create an endpoint each
+func endpointLabel(path string) string {
+	if i := strings.IndexByte(path[1:], '/'); i >= 0 {
+		return path[:i+1]
+	}
+	return path
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Components whose logging can be silenced separately with -quiet-for
+const (
+	componentHTTP     = "http"
+	componentWS       = "ws"
+	componentFTP      = "ftp"
+	componentRedis    = "redis"
+	componentWatchdog = "watchdog"
+	componentHooks    = "hooks"
+	componentTee      = "tee"
+)
+
+var logComponents = []string{componentHTTP, componentWS, componentFTP, componentRedis, componentWatchdog, componentHooks, componentTee}
+
+// quietSet is the set of silenced components, a flag.Value so it can be
+// given on the command line and replaced through /admin/config
+type quietSet struct {
+	mu         sync.RWMutex
+	components map[string]bool
+}
+
+var quietFor = &quietSet{}
+
+func (q *quietSet) String() string {
+	if q == nil {
+		return ""
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	names := make([]string, 0, len(q.components))
+	for name := range q.components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// Set replaces the silenced components with a comma separated list
+func (q *quietSet) Set(value string) error {
+	components := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !containsString(logComponents, name) {
+			return fmt.Errorf("unknown component %q, known: %s", name, strings.Join(logComponents, ","))
+		}
+		components[name] = true
+	}
+
+	q.mu.Lock()
+	q.components = components
+	q.mu.Unlock()
+	return nil
+}
+
+func (q *quietSet) has(component string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return q.components[component]
+}
+
+// isQuiet reports whether logging of component is silenced, -quiet
+// silences every component
+func isQuiet(component string) bool {
+	return quiet.Get() || quietFor.has(component)
+}
+
+// logf logs a line prefixed with its component unless that is silenced
+func logf(component, format string, args ...interface{}) {
+	if !isQuiet(component) {
+		log.Printf(component+": "+format, args...)
+	}
+}
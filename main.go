@@ -1,34 +1,83 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime/multipart"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 	"unsafe"
 
 	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/expvarhandler"
 	"github.com/valyala/fasthttp/reuseport"
 )
 
 type requestJSON struct {
 	URI         string            `json:"uri"`
 	Method      string            `json:"method"`
+	Protocol    string            `json:"protocol"`
 	Headers     map[string]string `json:"headers"`
 	ContentType string            `json:"content_type"`
 	Body        string            `json:"body"`
+	DelayMS     float64           `json:"delay_ms,omitempty"`
 }
 
-var quiet bool
+var quiet atomicBool
 
 func main() {
-	flag.BoolVar(&quiet, "quiet", false, "quiet")
+	// "check" runs the preflight checks against the remaining flags
+	// instead of starting the server
+	args := os.Args[1:]
+	preflight := len(args) > 0 && args[0] == "check"
+	if preflight {
+		args = args[1:]
+	}
+
+	flag.Var(&quiet, "quiet", "silence logging of every component")
+	flag.Var(quietFor, "quiet-for", "comma separated components to silence: "+strings.Join(logComponents, ","))
+	flag.Var(&compress, "compress", "compress generated responses according to Accept-Encoding")
 	addr := flag.String("addr", "0.0.0.0:8080", "server listen address")
-	flag.Parse()
+	flag.StringVar(&ftpAddr, "ftp-addr", "", "listen address of the FTP byte source (disabled when empty)")
+	flag.StringVar(&redisAddr, "redis-addr", "", "listen address of the Redis protocol sink (disabled when empty)")
+	flag.StringVar(&onStart, "on-start", "", "shell command to run once the server is listening")
+	flag.StringVar(&onDrain, "on-drain", "", "shell command to run when shutdown starts, before connections are drained")
+	flag.StringVar(&onShutdown, "on-shutdown", "", "shell command to run after the server has stopped")
+	flag.DurationVar(&hookTimeout, "hook-timeout", 30*time.Second, "timeout for each lifecycle hook command")
+	flag.DurationVar(&watchdogInterval, "watchdog-interval", 0, "interval of the self-checking watchdog (0 disables)")
+	flag.DurationVar(&watchdogTimeout, "watchdog-timeout", 5*time.Second, "timeout of each watchdog self-request")
+	flag.IntVar(&watchdogExitAfter, "watchdog-exit-after", 0, "exit when a watchdog check fails this many times in a row (0 never exits)")
+	flag.StringVar(&robotsFile, "robots-file", "", "file served as /robots.txt instead of the default that disallows /deny")
+	flag.BoolVar(&fixturesEICAR, "fixtures-eicar", false, "serve the EICAR anti-virus test file at /fixtures/eicar.com")
+	flag.Var(&costSampleRate, "cost-sample", "fraction of requests whose CPU time and allocations are recorded for /stats (0 disables)")
+	flag.DurationVar(&idleRST, "idle-rst", 0, "reset keep-alive connections with RST after being idle this long (0 disables)")
+	flag.StringVar(&teeDir, "tee-dir", "", "directory ?tee=true copies of responses are written to (disabled when empty)")
+	flag.Int64Var(&teeMaxBytes, "tee-max-bytes", 1<<20, "largest response body captured by ?tee=true")
+	flag.DurationVar(&continueDelay, "continue-delay", 0, "delay before answering Expect: 100-continue")
+	flag.Var(failNth, "fail-every", "fail one of every N requests with a status, as [route=]N[:status] (repeatable, 503 by default)")
+	flag.Var(profiles, "latency-profile", "named latency profile selected with ?profile=, as name:p50=20ms,p99=800ms (repeatable)")
+	flag.DurationVar(&clockSkew, "clock-skew", 0, "offset added to the time in Date, Expires and Last-Modified headers, e.g. -90s or 1h")
+	flag.Int64Var(&checksumSyncMax, "checksum-sync-max", 16<<20, "largest /bin pattern payload whose SHA-256 header is computed before responding, bigger ones get it once computed in the background, payloads above 1G never")
+	flag.StringVar(&wsProtocols, "ws-protocols", "", "comma separated WebSocket subprotocols accepted, in order of preference (the client's first offer when empty)")
+	flag.Var(&uploadMax, "upload-max", "largest body /upload reads before answering 413, unless ?max= is given (0 is unlimited)")
+	flag.Var(&connThreshold, "conn-threshold", "publish a threshold_breach event when open connections exceed this value (0 disables)")
+	flag.CommandLine.Parse(args)
+
+	if preflight {
+		os.Exit(runPreflight(*addr))
+	}
+
+	if err := loadRobots(); err != nil {
+		log.Fatalf("error loading robots file: %v", err)
+	}
 
 	// Create a new listener on the given address using port reuse
 	ln, err := reuseport.Listen("tcp4", *addr)
@@ -45,7 +94,13 @@ func main() {
 		WriteBufferSize: 1024 * 1024,
 		ReadTimeout:     90 * time.Second,
 		WriteTimeout:    5 * time.Second,
-		Handler:         requestHandler,
+		Handler:         withMetrics(withCost(withClockSkew(withTee(withRecover(withDrill(withOutage(withFailNth(withSharding(withHeaderDelay(withStatusHeader(withLatencyProfile(withCompress(requestHandler))))))))))))),
+		NoDefaultDate:   clockSkew != 0,
+		ConnState:       trackConnState,
+		ContinueHandler: continueHandler,
+		// Lets /upload stream bodies of any size, other handlers get
+		// theirs buffered up to maxBufferedBody by requestHandler
+		StreamRequestBody: true,
 	}
 
 	// Start the server in a goroutine
@@ -55,19 +110,59 @@ func main() {
 		}
 	}()
 
+	// Start the optional FTP byte source
+	if ftpAddr != "" {
+		ftpLn, err := net.Listen("tcp", ftpAddr)
+		if err != nil {
+			log.Fatalf("error creating FTP listener: %v", err)
+		}
+		defer ftpLn.Close()
+		go serveFTP(ftpLn)
+	}
+
+	// Start the optional Redis protocol sink
+	if redisAddr != "" {
+		redisLn, err := net.Listen("tcp", redisAddr)
+		if err != nil {
+			log.Fatalf("error creating Redis listener: %v", err)
+		}
+		defer redisLn.Close()
+		go serveRedis(redisLn)
+	}
+
+	// Start the optional self-checking watchdog
+	if watchdogInterval > 0 {
+		go wd.run(*addr)
+	}
+
+	runHook("start", onStart, *addr)
+
 	// Wait for a signal to stop the server
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	<-sig
 
+	runHook("drain", onDrain, *addr)
+
+	// Cut sleeping requests and streams short so they don't hold up the drain
+	close(draining)
+
+	// Let event subscribers know and end their streams, otherwise they
+	// would keep the server from shutting down
+	events.publish("drain_start", nil)
+	events.close()
+
 	// Stop the server
 	server.Shutdown()
+
+	runHook("shutdown", onShutdown, *addr)
 }
 
-func requestToJSON(req *fasthttp.Request) ([]byte, error) {
+func requestToJSON(req *fasthttp.Request, delay time.Duration) ([]byte, error) {
 	// Get the request URI, method, headers, content type, and body
 	uri := b2s(req.URI().FullURI())
 	method := b2s(req.Header.Method())
+	protocol := b2s(req.Header.Protocol())
 	headers := make(map[string]string)
 	req.Header.VisitAll(func(k, v []byte) {
 		headers[string(k)] = string(v)
@@ -79,17 +174,174 @@ func requestToJSON(req *fasthttp.Request) ([]byte, error) {
 	reqJSON := &requestJSON{
 		URI:         uri,
 		Method:      method,
+		Protocol:    protocol,
 		Headers:     headers,
 		ContentType: contentType,
 		Body:        body,
+		DelayMS:     float64(delay) / float64(time.Millisecond),
 	}
 	return json.Marshal(reqJSON)
 }
 
 func requestHandler(ctx *fasthttp.RequestCtx) {
-	jsonData, _ := requestToJSON(&ctx.Request)
+	path := b2s(ctx.Path())
+	if !streamsRequestBody(path) && !bufferRequestBody(ctx) {
+		return
+	}
+
+	switch {
+	case path == "/debug/vars":
+		expvarhandler.ExpvarHandler(ctx)
+	case path == "/health":
+		healthHandler(ctx)
+	case path == "/info":
+		infoHandler(ctx)
+	case path == "/stats":
+		statsHandler(ctx)
+	case path == "/stats/heatmap.svg":
+		heatmapHandler(ctx)
+	case path == "/admin/events":
+		eventsHandler(ctx)
+	case path == "/admin/config":
+		configHandler(ctx)
+	case path == "/admin/outage":
+		outageHandler(ctx)
+	case path == "/admin/fail-nth":
+		failNthHandler(ctx)
+	case path == "/admin/drill/goaway":
+		drillHandler(ctx)
+	case hasPathPrefix(path, "/anything"):
+		anythingHandler(ctx)
+	case path == "/cookies":
+		cookiesHandler(ctx)
+	case hasPathPrefix(path, "/cookies/set"):
+		cookiesSetHandler(ctx)
+	case path == "/cookies/delete":
+		cookiesDeleteHandler(ctx)
+	case strings.HasPrefix(path, "/redirect/"):
+		redirectHandler(ctx)
+	case path == "/redirect-to":
+		redirectToHandler(ctx)
+	case strings.HasPrefix(path, "/status/"):
+		statusHandler(ctx)
+	case hasPathPrefix(path, "/delay"):
+		delayHandler(ctx)
+	case path == "/drip":
+		dripHandler(ctx)
+	case strings.HasPrefix(path, "/stream/"):
+		streamHandler(ctx)
+	case path == "/html":
+		htmlHandler(ctx)
+	case path == "/xml":
+		xmlHandler(ctx)
+	case path == "/json":
+		jsonHandler(ctx)
+	case path == "/encoding/utf8":
+		utf8Handler(ctx)
+	case strings.HasPrefix(path, "/fixtures/"):
+		fixturesHandler(ctx)
+	case strings.HasPrefix(path, "/image/"):
+		imageHandler(ctx)
+	case path == "/early-hints":
+		earlyHintsHandler(ctx)
+	case path == "/ws/bin":
+		wsBinHandler(ctx)
+	case path == "/ws/fragmented":
+		wsFragmentedHandler(ctx)
+	case path == "/ws/close":
+		wsCloseHandler(ctx)
+	case path == "/ws/push":
+		wsPushHandler(ctx)
+	case path == "/ws/flaky":
+		wsFlakyHandler(ctx)
+	case path == "/ws/latency":
+		wsLatencyHandler(ctx)
+	case path == "/ws/stats":
+		wsStatsHandler(ctx)
+	case path == "/upload":
+		uploadHandler(ctx)
+	case path == "/put" || path == "/patch" || path == "/delete":
+		methodUploadHandler(ctx)
+	case path == "/upload/echo":
+		uploadEchoHandler(ctx)
+	case strings.HasPrefix(path, "/upload/progress/"):
+		progressHandler(ctx)
+	case path == "/reset":
+		resetHandler(ctx)
+	case path == "/close":
+		closeHandler(ctx)
+	case path == "/malformed/chunked":
+		malformedChunkedHandler(ctx)
+	case path == "/forms/post":
+		formsHandler(ctx)
+	case path == "/robots.txt":
+		robotsHandler(ctx)
+	case path == "/deny":
+		denyHandler(ctx)
+	case path == "/uuid":
+		uuidHandler(ctx)
+	case strings.HasPrefix(path, "/base64/"):
+		base64Handler(ctx)
+	case strings.HasPrefix(path, "/bytes/"):
+		bytesHandler(ctx)
+	case strings.HasPrefix(path, "/range/"):
+		rangeHandler(ctx)
+	case path == "/bin/infinite" || path == "/chunked/infinite":
+		infiniteHandler(ctx)
+	case strings.HasPrefix(path, "/bin/"):
+		binHandler(ctx)
+	case path == "/gzip":
+		gzipHandler(ctx)
+	case path == "/deflate":
+		deflateHandler(ctx)
+	case path == "/brotli":
+		brotliHandler(ctx)
+	case path == "/zstd":
+		zstdHandler(ctx)
+	case path == "/encoding":
+		encodingHandler(ctx)
+	case path == "/cache":
+		cacheHandler(ctx)
+	case strings.HasPrefix(path, "/cache/"):
+		cacheMaxAgeHandler(ctx)
+	case strings.HasPrefix(path, "/etag/"):
+		etagHandler(ctx)
+	case path == "/response-headers":
+		responseHeadersHandler(ctx)
+	default:
+		echoHandler(ctx)
+	}
+}
+
+// withRecover turns a handler panic into a 500 response instead of
+// crashing the whole server
+func withRecover(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		defer func() {
+			if r := recover(); r != nil {
+				handlerPanics.Add(1)
+				log.Printf("panic serving %s: %v", ctx.Path(), r)
+				events.publish("handler_panic", map[string]interface{}{
+					"path":  string(ctx.Path()),
+					"panic": fmt.Sprint(r),
+				})
+				ctx.Error("internal server error", fasthttp.StatusInternalServerError)
+			}
+		}()
+
+		h(ctx)
+	}
+}
 
-	if !quiet {
+func echoHandler(ctx *fasthttp.RequestCtx) {
+	ttfb, err := millisArg(ctx.QueryArgs(), "ttfb")
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	jsonData, _ := requestToJSON(&ctx.Request, appliedDelay(ctx))
+
+	if !isQuiet(componentHTTP) {
 		fmt.Println(b2s(jsonData))
 	}
 
@@ -97,9 +349,42 @@ func requestHandler(ctx *fasthttp.RequestCtx) {
 	ctx.Response.Header.SetContentLength(len(jsonData))
 	// ctx.Response.Header.Set("Connection", "keep-alive")
 	ctx.SetStatusCode(fasthttp.StatusOK)
+	if ttfb > 0 {
+		sendWithBodyDelay(ctx, ttfb, bytes.NewReader(jsonData), len(jsonData))
+		return
+	}
 	ctx.Write(jsonData)
 }
 
+// writeJSON marshals v and writes it as the response body with the given status
+func writeJSON(ctx *fasthttp.RequestCtx, statusCode int, v interface{}) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(statusCode)
+	ctx.SetBody(jsonData)
+}
+
+// readFormFile reads the whole content of an uploaded multipart file
+func readFormFile(fh *multipart.FileHeader) ([]byte, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// hasPathPrefix reports whether path is prefix itself or lies below it
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
 func b2s(b []byte) string {
 	return *(*string)(unsafe.Pointer(&b))
 }
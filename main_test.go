@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestMain(m *testing.M) {
+	quiet.Set("true")
+	os.Exit(m.Run())
+}
+
+// serveTest serves requestHandler on an in-memory listener set up like
+// the server in main and returns the listener to dial it
+func serveTest(t *testing.T) *fasthttputil.InmemoryListener {
+	t.Helper()
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := &fasthttp.Server{
+		Handler:           requestHandler,
+		ContinueHandler:   continueHandler,
+		StreamRequestBody: true,
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+// roundTrip sends req on a new connection and reads the response with
+// net/http, so the framing is checked by another implementation than the
+// one that wrote it. The body is read whole, trailers included.
+func roundTrip(t *testing.T, ln *fasthttputil.InmemoryListener, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Written alongside the read, the server may answer and close before
+	// it took the whole body
+	go req.Write(c)
+	return readResponse(t, bufio.NewReader(c), req)
+}
+
+// readResponse reads the response to req from br, body and trailers
+// included
+func readResponse(t *testing.T, br *bufio.Reader, req *http.Request) (*http.Response, []byte) {
+	t.Helper()
+
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	return resp, body
+}
+
+// newRequest builds a request for path, body may be nil
+func newRequest(t *testing.T, method, path string, body io.Reader) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, "http://hpdummy"+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+// patternBytes is n bytes of the /bin pattern starting at offset
+func patternBytes(offset, n int64) []byte {
+	b, _ := io.ReadAll(newPatternReader(offset, n))
+	return b
+}
+
+// dialRaw connects to ln for tests that speak the protocol themselves
+func dialRaw(t *testing.T, ln *fasthttputil.InmemoryListener) net.Conn {
+	t.Helper()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestRequestBodyTooLarge(t *testing.T) {
+	ln := serveTest(t)
+
+	body := strings.Repeat("x", maxBufferedBody+1)
+	resp, _ := roundTrip(t, ln, newRequest(t, "POST", "/anything", strings.NewReader(body)))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", resp.StatusCode)
+	}
+	if !resp.Close {
+		t.Error("connection not closed with the body left unread")
+	}
+
+	resp, _ = roundTrip(t, ln, newRequest(t, "POST", "/anything", strings.NewReader("small")))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+}
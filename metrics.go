@@ -0,0 +1,116 @@
+package main
+
+import (
+	"expvar"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Server counters published via expvar and served at /debug/vars
+var (
+	requestsTotal       = expvar.NewInt("requests_total")
+	requestBytesTotal   = expvar.NewInt("request_bytes_total")
+	responseBytesTotal  = expvar.NewInt("response_bytes_total")
+	responsesByStatus   = expvar.NewMap("responses_by_status")
+	connectionsTotal    = expvar.NewInt("connections_total")
+	connectionsOpen     = expvar.NewInt("connections_open")
+	connectionsHijacked = expvar.NewInt("connections_hijacked")
+	handlerPanics       = expvar.NewInt("handler_panics")
+)
+
+// connThreshold is the number of open connections above which a
+// threshold_breach event is published, 0 disables it
+var (
+	connThreshold         atomicInt64
+	connThresholdBreached int32
+)
+
+// withMetrics wraps a handler and updates the request counters and the
+// latency histograms once it returns
+func withMetrics(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		h(ctx)
+
+		now := time.Now()
+		recordLatency(b2s(ctx.Path()), now, now.Sub(ctx.Time()))
+
+		requestsTotal.Add(1)
+		// Streamed request bodies are counted by their declared length,
+		// reading them here would buffer what the handler left unread
+		if ctx.Request.IsBodyStream() {
+			if n := ctx.Request.Header.ContentLength(); n > 0 {
+				requestBytesTotal.Add(int64(n))
+			}
+		} else {
+			requestBytesTotal.Add(int64(len(ctx.Request.Body())))
+		}
+		// Streamed bodies are not buffered, so they can't be measured here
+		if !ctx.Response.IsBodyStream() {
+			responseBytesTotal.Add(int64(len(ctx.Response.Body())))
+		}
+		responsesByStatus.Add(strconv.Itoa(ctx.Response.StatusCode()), 1)
+	}
+}
+
+// trackConnState keeps the connection counters in sync with the server
+func trackConnState(c net.Conn, state fasthttp.ConnState) {
+	switch state {
+	case fasthttp.StateNew:
+		connectionsTotal.Add(1)
+		connectionsOpen.Add(1)
+		publishConnEvent("conn_open", c)
+	case fasthttp.StateHijacked:
+		disarmIdleRST(c)
+		connectionsHijacked.Add(1)
+		connectionsOpen.Add(-1)
+		publishConnEvent("conn_hijacked", c)
+	case fasthttp.StateClosed:
+		disarmIdleRST(c)
+		connectionsOpen.Add(-1)
+		publishConnEvent("conn_close", c)
+	case fasthttp.StateIdle:
+		armIdleRST(c)
+		return
+	case fasthttp.StateActive:
+		disarmIdleRST(c)
+		return
+	default:
+		return
+	}
+
+	checkConnThreshold()
+}
+
+// publishConnEvent skips building the event when nobody is listening,
+// this runs for every connection
+func publishConnEvent(typ string, c net.Conn) {
+	if events.active() {
+		events.publish(typ, map[string]interface{}{"remote_addr": c.RemoteAddr().String()})
+	}
+}
+
+// checkConnThreshold publishes an event once when open connections cross
+// above connThreshold and re-arms when they drop back below it
+func checkConnThreshold() {
+	threshold := connThreshold.Get()
+	if threshold <= 0 {
+		return
+	}
+
+	open := connectionsOpen.Value()
+	if open > threshold {
+		if atomic.CompareAndSwapInt32(&connThresholdBreached, 0, 1) {
+			events.publish("threshold_breach", map[string]interface{}{
+				"metric":    "connections_open",
+				"value":     open,
+				"threshold": threshold,
+			})
+		}
+	} else {
+		atomic.StoreInt32(&connThresholdBreached, 0)
+	}
+}
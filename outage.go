@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// outage forces every non-admin response to one status until it ends
+type outage struct {
+	// until is the end of the current outage in Unix nanoseconds, 0 when
+	// there is none, so the request path is a single atomic load
+	until  int64
+	status int64
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+var outages = &outage{}
+
+// outageReport is the JSON view of the current outage
+type outageReport struct {
+	Active    bool      `json:"active"`
+	Status    int       `json:"status,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+	Remaining string    `json:"remaining,omitempty"`
+}
+
+func (o *outage) start(status int, duration time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	atomic.StoreInt64(&o.status, int64(status))
+	atomic.StoreInt64(&o.until, time.Now().Add(duration).UnixNano())
+	o.timer = time.AfterFunc(duration, o.stop)
+
+	events.publish("outage_start", map[string]interface{}{
+		"status":   status,
+		"duration": duration.String(),
+	})
+}
+
+func (o *outage) stop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	if atomic.SwapInt64(&o.until, 0) != 0 {
+		events.publish("outage_end", nil)
+	}
+}
+
+// active returns the status to respond with while an outage lasts, or 0
+func (o *outage) active() int {
+	until := atomic.LoadInt64(&o.until)
+	if until == 0 || time.Now().UnixNano() >= until {
+		return 0
+	}
+	return int(atomic.LoadInt64(&o.status))
+}
+
+func (o *outage) report() *outageReport {
+	status := o.active()
+	if status == 0 {
+		return &outageReport{}
+	}
+	until := time.Unix(0, atomic.LoadInt64(&o.until))
+	return &outageReport{
+		Active:    true,
+		Status:    status,
+		Until:     until,
+		Remaining: time.Until(until).Round(time.Second).String(),
+	}
+}
+
+// withOutage answers every request outside /admin/ with the outage status
+// while an outage is in progress, except the watchdog's probes
+func withOutage(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if status := outages.active(); status != 0 && !strings.HasPrefix(b2s(ctx.Path()), "/admin/") && !isWatchdogRequest(ctx) {
+			ctx.Error("simulated outage", status)
+			return
+		}
+		h(ctx)
+	}
+}
+
+// outageHandler starts an outage on POST, ends it on DELETE and reports
+// on it on GET: POST /admin/outage?status=503&duration=30s
+func outageHandler(ctx *fasthttp.RequestCtx) {
+	switch {
+	case ctx.IsPost():
+		args := ctx.QueryArgs()
+		status, err := intArg(args, "status", fasthttp.StatusServiceUnavailable)
+		if err != nil || status < 200 || status > 599 {
+			ctx.Error("status must be a status code between 200 and 599", fasthttp.StatusBadRequest)
+			return
+		}
+		duration, err := time.ParseDuration(string(args.Peek("duration")))
+		if err != nil || duration <= 0 {
+			ctx.Error("duration must be a positive duration, e.g. 30s", fasthttp.StatusBadRequest)
+			return
+		}
+		outages.start(status, duration)
+	case ctx.IsDelete():
+		outages.stop()
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, outages.report())
+}
@@ -0,0 +1,9 @@
+package main
+
+import "net"
+
+// peerClosed can't peek at sockets on Windows, sleeping requests only
+// give up when the server drains
+func peerClosed(c net.Conn) bool {
+	return false
+}
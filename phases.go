@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errInvalidMillis = errors.New("delays must be milliseconds (50) or durations (1.5s) up to " + maxDelay.String())
+
+// phaseDelays parses ?header_delay=&body_delay=, the time to wait before
+// the status line and headers and between the headers and the body, in
+// milliseconds like every delay of the request. ?ttfb= is added to the
+// body delay, it holds back only the first byte of the body.
+func phaseDelays(ctx *fasthttp.RequestCtx) (header, body time.Duration, err error) {
+	args := ctx.QueryArgs()
+	if header, err = millisArg(args, "header_delay"); err != nil {
+		return 0, 0, err
+	}
+	if body, err = millisArg(args, "body_delay"); err != nil {
+		return 0, 0, err
+	}
+	ttfb, err := millisArg(args, "ttfb")
+	if err != nil {
+		return 0, 0, err
+	}
+	return header, body + ttfb, nil
+}
+
+// millisArg parses the query argument name given in milliseconds or as a
+// Go duration such as 1.5s, it's zero when missing
+func millisArg(args *fasthttp.Args, name string) (time.Duration, error) {
+	v := b2s(args.Peek(name))
+	if v == "" {
+		return 0, nil
+	}
+	return parseMillis(v)
+}
+
+// parseMillis parses milliseconds, fractions allowed, or a Go duration
+// such as 1.5s, up to maxDelay
+func parseMillis(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		ms, ferr := strconv.ParseFloat(v, 64)
+		if ferr != nil {
+			return 0, errInvalidMillis
+		}
+		d = time.Duration(ms * float64(time.Millisecond))
+	}
+	if d < 0 || d > maxDelay {
+		return 0, errInvalidMillis
+	}
+	return d, nil
+}
+
+// setBodyStream sets the response body, or with a body delay, an abort or
+// a checksum trailer hands it to sendWithBodyDelay or
+// sendWithChecksumTrailer. HEAD requests only get the Content-Length, the
+// body is never read so HEAD preflights of huge payloads cost nothing.
+func setBodyStream(ctx *fasthttp.RequestCtx, body io.Reader, size int, bodyDelay time.Duration) {
+	if ctx.IsHead() {
+		ctx.Response.Header.SetContentLength(size)
+		ctx.Response.SkipBody = true
+		return
+	}
+	_, aborting := abortAfter(ctx)
+	if wantsChecksumTrailer(ctx) && !aborting {
+		sendWithChecksumTrailer(ctx, bodyDelay, body, size)
+		return
+	}
+	if bodyDelay > 0 || aborting {
+		sendWithBodyDelay(ctx, bodyDelay, body, size)
+		return
+	}
+	ctx.SetBodyStream(body, size)
+}
+
+// sendWithBodyDelay writes the response headers on their own, waits delay
+// and then writes size bytes of body, or only ?abort_after= bytes. fasthttp
+// only flushes headers together with the body, so the connection is
+// hijacked to write them directly and closed once the body is sent. HEAD
+// requests get the headers right away and no body, as in setBodyStream.
+func sendWithBodyDelay(ctx *fasthttp.RequestCtx, delay time.Duration, body io.Reader, size int) {
+	if ctx.IsHead() {
+		ctx.Response.Header.SetContentLength(size)
+		ctx.Response.SkipBody = true
+		return
+	}
+
+	n := int64(size)
+	if limit, ok := abortAfter(ctx); ok && limit < n {
+		n = limit
+	}
+	ctx.Response.Header.SetContentLength(size)
+	ctx.Response.SetConnectionClose()
+	header := hijackHeader(ctx)
+
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(c net.Conn) {
+		if _, err := c.Write(header); err != nil {
+			return
+		}
+		if !sleepStream(delay) {
+			return
+		}
+		io.CopyN(c, body, n)
+	})
+}
+
+// setBodyStreamWriter sets a body of unknown length written by fn, or with
+// a body delay or an abort hands it to sendChunked
+func setBodyStreamWriter(ctx *fasthttp.RequestCtx, bodyDelay time.Duration, fn fasthttp.StreamWriter) {
+	if _, aborting := abortAfter(ctx); bodyDelay > 0 || aborting {
+		sendChunked(ctx, bodyDelay, nil, fn)
+		return
+	}
+	ctx.SetBodyStreamWriter(fn)
+}
+
+// sendChunked is sendWithBodyDelay for bodies of unknown length, what fn
+// writes is sent chunked and followed by the trailers, which are declared
+// in the Trailer header. With ?abort_after= the connection is closed in the
+// middle of the chunk that crosses the limit.
+func sendChunked(ctx *fasthttp.RequestCtx, delay time.Duration, trailers []trailer, fn fasthttp.StreamWriter) {
+	limit, ok := abortAfter(ctx)
+	if !ok {
+		limit = -1
+	}
+	ctx.Response.Header.SetContentLength(-1)
+	if len(trailers) > 0 {
+		ctx.Response.Header.Set(fasthttp.HeaderTrailer, declareTrailers(trailers))
+	}
+	ctx.Response.SetConnectionClose()
+	header := hijackHeader(ctx)
+
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(func(c net.Conn) {
+		if _, err := c.Write(header); err != nil {
+			return
+		}
+		if !sleepStream(delay) {
+			return
+		}
+
+		w := bufio.NewWriterSize(&chunkedWriter{w: c, left: limit}, 32<<10)
+		fn(w)
+		if w.Flush() == nil {
+			c.Write(appendTrailers(nil, trailers))
+		}
+	})
+}
+
+// chunkedWriter encodes every Write as one HTTP/1.1 chunk. Once left
+// payload bytes, unless negative, are written it fails with errAborted.
+type chunkedWriter struct {
+	w    io.Writer
+	left int64
+}
+
+func (cw *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	data := p
+	if cw.left >= 0 && int64(len(data)) > cw.left {
+		data = data[:cw.left]
+	}
+
+	chunk := make([]byte, 0, len(data)+20)
+	chunk = strconv.AppendInt(chunk, int64(len(p)), 16)
+	chunk = append(chunk, "\r\n"...)
+	chunk = append(chunk, data...)
+	if len(data) < len(p) {
+		cw.w.Write(chunk)
+		cw.left = 0
+		return len(data), errAborted
+	}
+	chunk = append(chunk, "\r\n"...)
+	if _, err := cw.w.Write(chunk); err != nil {
+		return 0, err
+	}
+	if cw.left >= 0 {
+		cw.left -= int64(len(p))
+	}
+	return len(p), nil
+}
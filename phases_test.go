@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestChunkedWriter(t *testing.T) {
+	var buf bytes.Buffer
+	cw := &chunkedWriter{w: &buf, left: -1}
+
+	for _, s := range []string{"hello", "", "0123456789abcdefXYZ"} {
+		n, err := cw.Write([]byte(s))
+		if err != nil || n != len(s) {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	if want := "5\r\nhello\r\n13\r\n0123456789abcdefXYZ\r\n"; buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestChunkedWriterAbort(t *testing.T) {
+	var buf bytes.Buffer
+	cw := &chunkedWriter{w: &buf, left: 7}
+
+	if n, err := cw.Write([]byte("hello")); n != 5 || err != nil {
+		t.Fatalf("first Write = %d, %v", n, err)
+	}
+	// The chunk crossing the limit announces its full size and stops
+	// short, the client sees a truncated chunk
+	n, err := cw.Write([]byte("world"))
+	if n != 2 || err != errAborted {
+		t.Fatalf("second Write = %d, %v, want 2, errAborted", n, err)
+	}
+	if want := "5\r\nhello\r\n5\r\nwo"; buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+}
+
+func TestBodyDelayHead(t *testing.T) {
+	ln := serveTest(t)
+
+	// A body written after the headers of a HEAD response would be read
+	// as the next response on the connection
+	c := dialRaw(t, ln)
+	head := newRequest(t, "HEAD", "/anything?ttfb=1", nil)
+	get := newRequest(t, "GET", "/health", nil)
+	head.Write(c)
+	get.Write(c)
+
+	br := bufio.NewReader(c)
+	resp, _ := readResponse(t, br, head)
+	if resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 {
+		t.Fatalf("HEAD: status %d, Content-Length %d", resp.StatusCode, resp.ContentLength)
+	}
+	resp, body := readResponse(t, br, get)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok\n" {
+		t.Errorf("GET after HEAD: status %d, body %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const profileDelayHeader = "X-HPDummy-Profile-Delay"
+
+// latencyPoint is one percentile of a latency profile
+type latencyPoint struct {
+	quantile float64
+	delay    time.Duration
+}
+
+// latencyProfile is the inverse CDF of a latency distribution given by a
+// few percentiles, interpolated linearly between them and from zero up to
+// the first one
+type latencyProfile []latencyPoint
+
+// sample draws a delay from the profile
+func (p latencyProfile) sample() time.Duration {
+	q := rand.Float64()
+
+	prev := latencyPoint{}
+	for _, pt := range p {
+		if q <= pt.quantile {
+			frac := (q - prev.quantile) / (pt.quantile - prev.quantile)
+			return prev.delay + time.Duration(frac*float64(pt.delay-prev.delay))
+		}
+		prev = pt
+	}
+	return prev.delay
+}
+
+func (p latencyProfile) String() string {
+	parts := make([]string, len(p))
+	for i, pt := range p {
+		parts[i] = "p" + strconv.FormatFloat(pt.quantile*100, 'f', -1, 64) + "=" + pt.delay.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseLatencyProfile parses "p50=20ms,p99=800ms", p100 sets the maximum
+func parseLatencyProfile(s string) (latencyProfile, error) {
+	var p latencyProfile
+	for _, part := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || !strings.HasPrefix(name, "p") {
+			return nil, fmt.Errorf("expected p<percentile>=<duration>, got %q", part)
+		}
+		pct, err := strconv.ParseFloat(name[1:], 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return nil, fmt.Errorf("invalid percentile %q", name)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 || d > maxDelay {
+			return nil, fmt.Errorf("invalid delay %q", value)
+		}
+		p = append(p, latencyPoint{quantile: pct / 100, delay: d})
+	}
+
+	sort.Slice(p, func(i, j int) bool { return p[i].quantile < p[j].quantile })
+	for i := 1; i < len(p); i++ {
+		if p[i].quantile == p[i-1].quantile || p[i].delay < p[i-1].delay {
+			return nil, fmt.Errorf("percentiles must be distinct and their delays must not decrease")
+		}
+	}
+	return p, nil
+}
+
+// latencyProfiles maps profile names to profiles, a flag.Value taking
+// name:p50=20ms,p99=800ms and repeatable to define several
+type latencyProfiles struct {
+	mu       sync.RWMutex
+	profiles map[string]latencyProfile
+}
+
+var profiles = &latencyProfiles{}
+
+func (l *latencyProfiles) String() string {
+	if l == nil {
+		return ""
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	names := make([]string, 0, len(l.profiles))
+	for name := range l.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		names[i] = name + ":" + l.profiles[name].String()
+	}
+	return strings.Join(names, " ")
+}
+
+// Set adds or replaces a single profile
+func (l *latencyProfiles) Set(value string) error {
+	name, spec, ok := strings.Cut(value, ":")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name:p50=20ms,p99=800ms")
+	}
+	p, err := parseLatencyProfile(spec)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.profiles == nil {
+		l.profiles = make(map[string]latencyProfile)
+	}
+	l.profiles[name] = p
+	return nil
+}
+
+func (l *latencyProfiles) get(name string) (latencyProfile, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	p, ok := l.profiles[name]
+	return p, ok
+}
+
+// withLatencyProfile delays requests carrying ?profile= by a sample of
+// the named profile, reported in X-HPDummy-Profile-Delay and added to the
+// delay in echo JSON
+func withLatencyProfile(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		name := ctx.QueryArgs().Peek("profile")
+		if len(name) == 0 {
+			h(ctx)
+			return
+		}
+
+		p, ok := profiles.get(b2s(name))
+		if !ok {
+			ctx.Error("unknown latency profile "+string(name), fasthttp.StatusBadRequest)
+			return
+		}
+
+		delay := p.sample()
+		if !sleepRequest(ctx, delay) {
+			return
+		}
+		ctx.SetUserValue(appliedDelayKey, appliedDelay(ctx)+delay)
+
+		h(ctx)
+
+		ctx.Response.Header.Set(profileDelayHeader, delay.String())
+	}
+}
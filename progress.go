@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// progressInterval is the time between updates of a progress stream
+	progressInterval = 100 * time.Millisecond
+
+	// progressRetention is how long finished uploads can still be looked up
+	progressRetention = time.Minute
+
+	maxProgressIDLen = 128
+)
+
+// uploadProgress tracks an in-flight /upload?progress_id=
+type uploadProgress struct {
+	id       string
+	expected int64
+	started  time.Time
+
+	received int64 // atomic
+	finished int64 // atomic, unix nanoseconds, 0 while in flight
+}
+
+// progressReport is the JSON view of an uploadProgress
+type progressReport struct {
+	ID        string  `json:"id"`
+	Received  int64   `json:"received"`
+	Expected  int64   `json:"expected"`
+	ElapsedMS float64 `json:"elapsed_ms"`
+	Done      bool    `json:"done"`
+}
+
+var progresses = struct {
+	sync.Mutex
+	m map[string]*uploadProgress
+}{m: make(map[string]*uploadProgress)}
+
+// startProgress registers the progress of an upload under id, replacing
+// any previous upload with that id. expected is the Content-Length, -1
+// for chunked bodies.
+func startProgress(id string, expected int64) *uploadProgress {
+	p := &uploadProgress{id: id, expected: expected, started: time.Now()}
+
+	progresses.Lock()
+	progresses.m[id] = p
+	progresses.Unlock()
+	return p
+}
+
+func (p *uploadProgress) add(n int) {
+	atomic.AddInt64(&p.received, int64(n))
+}
+
+// finish marks the upload done and forgets it after progressRetention
+func (p *uploadProgress) finish() {
+	atomic.StoreInt64(&p.finished, time.Now().UnixNano())
+	time.AfterFunc(progressRetention, func() {
+		progresses.Lock()
+		defer progresses.Unlock()
+		if progresses.m[p.id] == p {
+			delete(progresses.m, p.id)
+		}
+	})
+}
+
+func (p *uploadProgress) report() *progressReport {
+	end := time.Now()
+	finished := atomic.LoadInt64(&p.finished)
+	if finished != 0 {
+		end = time.Unix(0, finished)
+	}
+	return &progressReport{
+		ID:        p.id,
+		Received:  atomic.LoadInt64(&p.received),
+		Expected:  p.expected,
+		ElapsedMS: milliseconds(end.Sub(p.started)),
+		Done:      finished != 0,
+	}
+}
+
+func lookupProgress(id string) *uploadProgress {
+	progresses.Lock()
+	defer progresses.Unlock()
+	return progresses.m[id]
+}
+
+// validProgressID reports whether id can name an upload progress
+func validProgressID(id string) bool {
+	return id != "" && len(id) <= maxProgressIDLen && isToken(id)
+}
+
+// progressHandler serves /upload/progress/{id}, the bytes received so far
+// by the /upload?progress_id={id} in flight, as JSON or, with
+// Accept: text/event-stream or ?stream=true, as Server-Sent Events every
+// 100ms until the upload is done. Comparing it with what the client has
+// sent shows whether a proxy buffers request bodies.
+func progressHandler(ctx *fasthttp.RequestCtx) {
+	p := lookupProgress(strings.TrimPrefix(b2s(ctx.Path()), "/upload/progress/"))
+	if p == nil {
+		ctx.Error("unknown upload", fasthttp.StatusNotFound)
+		return
+	}
+
+	accept := b2s(ctx.Request.Header.Peek(fasthttp.HeaderAccept))
+	if !ctx.QueryArgs().GetBool("stream") && !strings.Contains(accept, "text/event-stream") {
+		writeJSON(ctx, fasthttp.StatusOK, p.report())
+		return
+	}
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		for {
+			r := p.report()
+			data, _ := json.Marshal(r)
+			fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+			if err := w.Flush(); err != nil || r.Done {
+				return
+			}
+			if !sleepStream(progressInterval) {
+				return
+			}
+		}
+	})
+}
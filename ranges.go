@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// maxRanges bounds the number of ranges served in one multipart response
+const maxRanges = 64
+
+var errUnsatisfiableRange = errors.New("unsatisfiable range")
+
+// byteRange is an inclusive-exclusive [start, end) slice of the content
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end-1, size)
+}
+
+// contentSource returns a reader for n bytes of content starting at offset
+type contentSource func(offset, n int64) io.Reader
+
+// parseRanges parses a "bytes=" Range header against content of the given
+// size. Ranges outside the content are dropped; if none are left
+// errUnsatisfiableRange is returned. A header this server doesn't understand
+// yields no ranges and no error, so the full content is served.
+func parseRanges(header string, size int64) ([]byteRange, error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return nil, nil
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+
+	var ranges []byteRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, nil
+		}
+
+		var r byteRange
+		switch {
+		case first == "":
+			// Suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, end: size}
+		default:
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size
+			if last != "" {
+				e, err := strconv.ParseInt(last, 10, 64)
+				if err != nil || e < start {
+					return nil, nil
+				}
+				if e+1 < end {
+					end = e + 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, end: end}
+		}
+
+		if len(ranges) == maxRanges {
+			return nil, errUnsatisfiableRange
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// serveContent responds with content of the given size read from src,
+// honoring Range requests: a single range is served as 206 with
+// Content-Range, several ranges, or those of ?multipart_ranges=0-99,200-,
+// as a multipart/byteranges body.
+// ?header_delay= and ?body_delay= hold back the headers and the body.
+func serveContent(ctx *fasthttp.RequestCtx, contentType string, size int64, src contentSource) {
+	headerDelay, bodyDelay, err := phaseDelays(ctx)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	if !sleepRequest(ctx, headerDelay) {
+		return
+	}
+
+	ctx.Response.Header.Set(fasthttp.HeaderAcceptRanges, "bytes")
+
+	// ?multipart_ranges= stands in for a Range header and always gets a
+	// multipart body, even for a single range
+	header := string(ctx.Request.Header.Peek(fasthttp.HeaderRange))
+	forceMultipart := false
+	if v := ctx.QueryArgs().Peek("multipart_ranges"); header == "" && len(v) > 0 {
+		header, forceMultipart = "bytes="+string(v), true
+	}
+
+	var ranges []byteRange
+	if header != "" {
+		ranges, err = parseRanges(header, size)
+		if err != nil {
+			// ctx.Error resets the header, Content-Range goes after it
+			ctx.Error(err.Error(), fasthttp.StatusRequestedRangeNotSatisfiable)
+			ctx.Response.Header.Set(fasthttp.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+			return
+		}
+	}
+
+	switch {
+	case len(ranges) == 0:
+		ctx.SetContentType(contentType)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		setBodyStream(ctx, src(0, size), int(size), bodyDelay)
+	case len(ranges) == 1 && !forceMultipart:
+		r := ranges[0]
+		ctx.SetContentType(contentType)
+		ctx.Response.Header.Set(fasthttp.HeaderContentRange, r.contentRange(size))
+		ctx.SetStatusCode(fasthttp.StatusPartialContent)
+		setBodyStream(ctx, src(r.start, r.length()), int(r.length()), bodyDelay)
+	default:
+		body, n, boundary := multipartRanges(ranges, contentType, size, src)
+		ctx.SetContentType("multipart/byteranges; boundary=" + boundary)
+		ctx.SetStatusCode(fasthttp.StatusPartialContent)
+		setBodyStream(ctx, body, int(n), bodyDelay)
+	}
+}
+
+// multipartRanges assembles a multipart/byteranges body lazily, only the
+// part headers are held in memory. It returns the body, its exact length
+// and the boundary used.
+func multipartRanges(ranges []byteRange, contentType string, size int64, src contentSource) (io.Reader, int64, string) {
+	boundary := newBoundary()
+
+	readers := make([]io.Reader, 0, 2*len(ranges)+1)
+	var n int64
+	for i, r := range ranges {
+		header := fmt.Sprintf("--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			boundary, contentType, r.contentRange(size))
+		if i > 0 {
+			header = "\r\n" + header
+		}
+		readers = append(readers, strings.NewReader(header), src(r.start, r.length()))
+		n += int64(len(header)) + r.length()
+	}
+	trailer := "\r\n--" + boundary + "--\r\n"
+	readers = append(readers, strings.NewReader(trailer))
+	n += int64(len(trailer))
+
+	return io.MultiReader(readers...), n, boundary
+}
+
+// newBoundary returns a random multipart boundary
+func newBoundary() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+func TestParseRanges(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		want   []byteRange
+		err    error
+	}{
+		{"bytes=0-9", []byteRange{{0, 10}}, nil},
+		{"bytes=90-", []byteRange{{90, 100}}, nil},
+		{"bytes=-10", []byteRange{{90, 100}}, nil},
+		{"bytes=95-200", []byteRange{{95, 100}}, nil},
+		{"bytes=0-0, 5-6", []byteRange{{0, 1}, {5, 7}}, nil},
+		{"bytes=100-", nil, errUnsatisfiableRange},
+		{"bytes=-0", nil, errUnsatisfiableRange},
+		{"bytes=5-1", nil, nil},
+		{"items=0-1", nil, nil},
+	} {
+		got, err := parseRanges(tc.header, 100)
+		if err != tc.err {
+			t.Errorf("%s: err = %v, want %v", tc.header, err, tc.err)
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: ranges = %v, want %v", tc.header, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: ranges = %v, want %v", tc.header, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestBinSingleRange(t *testing.T) {
+	ln := serveTest(t)
+
+	req := newRequest(t, "GET", "/bin/100", nil)
+	req.Header.Set("Range", "bytes=10-19")
+	resp, body := roundTrip(t, ln, req)
+
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 10-19/100" {
+		t.Errorf("Content-Range = %q", got)
+	}
+	if want := patternBytes(10, 10); !bytes.Equal(body, want) {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestBinMultipleRanges(t *testing.T) {
+	ln := serveTest(t)
+
+	req := newRequest(t, "GET", "/bin/100", nil)
+	req.Header.Set("Range", "bytes=0-4,50-54")
+	resp, body := roundTrip(t, ln, req)
+
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Fatalf("status = %d, want 206", resp.StatusCode)
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		t.Fatalf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for _, want := range []struct {
+		contentRange string
+		offset       int64
+	}{
+		{"bytes 0-4/100", 0},
+		{"bytes 50-54/100", 50},
+	} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("reading part %q: %v", want.contentRange, err)
+		}
+		if got := part.Header.Get("Content-Range"); got != want.contentRange {
+			t.Errorf("part Content-Range = %q, want %q", got, want.contentRange)
+		}
+		data, _ := io.ReadAll(part)
+		if !bytes.Equal(data, patternBytes(want.offset, 5)) {
+			t.Errorf("part %q = %q", want.contentRange, data)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("after the ranges: %v, want io.EOF", err)
+	}
+}
+
+func TestBinUnsatisfiableRange(t *testing.T) {
+	ln := serveTest(t)
+
+	req := newRequest(t, "GET", "/bin/100", nil)
+	req.Header.Set("Range", "bytes=200-")
+	resp, _ := roundTrip(t, ln, req)
+
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("status = %d, want 416", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes */100" {
+		t.Errorf("Content-Range = %q, want bytes */100", got)
+	}
+}
+
+func TestIfRange(t *testing.T) {
+	ln := serveTest(t)
+
+	// The validators come from a plain request, as a resuming client has them
+	resp, _ := roundTrip(t, ln, newRequest(t, "GET", "/range/100", nil))
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("ETag = %q, Last-Modified = %q", etag, lastModified)
+	}
+
+	for _, tc := range []struct {
+		ifRange string
+		want    int
+	}{
+		{etag, http.StatusPartialContent},
+		{lastModified, http.StatusPartialContent},
+		{`"other"`, http.StatusOK},
+		{"W/" + etag, http.StatusOK},
+		{"Thu, 01 Jan 1970 00:00:00 GMT", http.StatusOK},
+	} {
+		req := newRequest(t, "GET", "/range/100", nil)
+		req.Header.Set("Range", "bytes=0-9")
+		req.Header.Set("If-Range", tc.ifRange)
+		resp, body := roundTrip(t, ln, req)
+
+		if resp.StatusCode != tc.want {
+			t.Errorf("If-Range %s: status = %d, want %d", tc.ifRange, resp.StatusCode, tc.want)
+			continue
+		}
+		wantLen := 100
+		if tc.want == http.StatusPartialContent {
+			wantLen = 10
+		}
+		if len(body) != wantLen {
+			t.Errorf("If-Range %s: %d bytes, want %d", tc.ifRange, len(body), wantLen)
+		}
+	}
+}
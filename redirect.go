@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// redirectHandler serves /redirect/{n}, a chain of n 302 hops that ends
+// at /anything. Hops use relative Location headers unless ?absolute=true.
+func redirectHandler(ctx *fasthttp.RequestCtx) {
+	n, err := strconv.Atoi(strings.TrimPrefix(b2s(ctx.Path()), "/redirect/"))
+	if err != nil || n < 1 {
+		ctx.Error("expected /redirect/{n} with n >= 1", fasthttp.StatusBadRequest)
+		return
+	}
+
+	absolute := ctx.QueryArgs().GetBool("absolute")
+
+	next := "/anything"
+	if n > 1 {
+		next = "/redirect/" + strconv.Itoa(n-1)
+		if absolute {
+			next += "?absolute=true"
+		}
+	}
+	if absolute {
+		ctx.Redirect(next, fasthttp.StatusFound)
+		return
+	}
+
+	ctx.Response.Header.Set(fasthttp.HeaderLocation, next)
+	ctx.SetStatusCode(fasthttp.StatusFound)
+}
+
+// redirectToHandler serves /redirect-to?url=&status_code=, the url is sent
+// verbatim so both absolute and relative targets can be produced
+func redirectToHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+
+	url := args.Peek("url")
+	if len(url) == 0 {
+		ctx.Error("missing url parameter", fasthttp.StatusBadRequest)
+		return
+	}
+	if !validHeaderValue(url) {
+		ctx.Error("url must be free of control characters", fasthttp.StatusBadRequest)
+		return
+	}
+
+	statusCode := fasthttp.StatusFound
+	if args.Has("status_code") {
+		code, err := args.GetUint("status_code")
+		if err != nil || code < 300 || code > 399 {
+			ctx.Error("status_code must be a 3xx code", fasthttp.StatusBadRequest)
+			return
+		}
+		statusCode = code
+	}
+
+	ctx.Response.Header.SetBytesV(fasthttp.HeaderLocation, url)
+	ctx.SetStatusCode(statusCode)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRedisBulkLen bounds a single argument so a bad client can't make the
+// sink allocate arbitrary amounts of memory
+const maxRedisBulkLen = 64 << 20
+
+// redisAddr enables the Redis protocol sink when set
+var redisAddr string
+
+var errRedisProtocol = errors.New("protocol error")
+
+// serveRedis runs a sink speaking the subset of the Redis protocol that
+// connectivity checks use: PING, ECHO, GET, SET, DEL, EXISTS, DBSIZE, QUIT.
+// Both RESP arrays and inline commands are understood.
+func serveRedis(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logf(componentRedis, "accept error: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go serveRedisConn(c)
+	}
+}
+
+func serveRedisConn(c net.Conn) {
+	defer c.Close()
+
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			if errors.Is(err, errRedisProtocol) {
+				fmt.Fprintf(w, "-ERR %v\r\n", err)
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := handleRedisCommand(w, args)
+		// Pipelined commands are answered in one write
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+func handleRedisCommand(w *bufio.Writer, args []string) (quit bool) {
+	switch cmd := strings.ToUpper(args[0]); {
+	case cmd == "PING" && len(args) == 1:
+		w.WriteString("+PONG\r\n")
+	case cmd == "PING" && len(args) == 2, cmd == "ECHO" && len(args) == 2:
+		writeRedisBulk(w, []byte(args[1]))
+	case cmd == "GET" && len(args) == 2:
+		v, ok := kv.get(args[1])
+		if !ok {
+			w.WriteString("$-1\r\n")
+			break
+		}
+		writeRedisBulk(w, v)
+	case cmd == "SET" && len(args) >= 3:
+		// Options like EX/PX/NX are accepted and ignored
+		kv.set(args[1], []byte(args[2]))
+		w.WriteString("+OK\r\n")
+	case cmd == "DEL" && len(args) >= 2:
+		fmt.Fprintf(w, ":%d\r\n", kv.del(args[1:]...))
+	case cmd == "EXISTS" && len(args) >= 2:
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := kv.get(key); ok {
+				n++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case cmd == "DBSIZE":
+		fmt.Fprintf(w, ":%d\r\n", kv.len())
+	case cmd == "SELECT", cmd == "CLIENT":
+		w.WriteString("+OK\r\n")
+	case cmd == "COMMAND":
+		w.WriteString("*0\r\n")
+	case cmd == "QUIT":
+		w.WriteString("+OK\r\n")
+		return true
+	default:
+		fmt.Fprintf(w, "-ERR unknown command or wrong number of arguments for '%s'\r\n", args[0])
+	}
+	return false
+}
+
+func writeRedisBulk(w *bufio.Writer, v []byte) {
+	fmt.Fprintf(w, "$%d\r\n", len(v))
+	w.Write(v)
+	w.WriteString("\r\n")
+}
+
+// readRedisCommand reads either a RESP array of bulk strings or an inline
+// command line
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRedisLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > 1024*1024 {
+		return nil, errRedisProtocol
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readRedisLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errRedisProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxRedisBulkLen {
+			return nil, errRedisProtocol
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readRedisLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
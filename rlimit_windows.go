@@ -0,0 +1,8 @@
+package main
+
+import "errors"
+
+// openFilesLimit has no equivalent on Windows
+func openFilesLimit() (uint64, uint64, error) {
+	return 0, 0, errors.New("not available on windows")
+}
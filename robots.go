@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+
+	"github.com/valyala/fasthttp"
+)
+
+// defaultRobots keeps crawlers away from /deny as httpbin does
+const defaultRobots = "User-agent: *\nDisallow: /deny\n"
+
+// denyPage is httpbin's /deny body
+const denyPage = `
+          .-''''''-.
+        .' _      _ '.
+       /   O      O   \
+      :                :
+      |                |
+      :       __       :
+       \  .-"` + "`" + `  ` + "`" + `"-.  /
+        '.          .'
+          '-......-'
+     YOU SHOULDN'T BE HERE
+`
+
+// robotsFile replaces the default /robots.txt when set
+var robotsFile string
+
+// robots is the served /robots.txt, loaded once at startup
+var robots = []byte(defaultRobots)
+
+// loadRobots reads robotsFile if one is configured
+func loadRobots() error {
+	if robotsFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(robotsFile)
+	if err != nil {
+		return err
+	}
+	robots = b
+	return nil
+}
+
+func robotsHandler(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBody(robots)
+}
+
+// denyHandler serves /deny, the page /robots.txt disallows by default
+var denyHandler = sampleHandler("text/plain; charset=utf-8", denyPage)
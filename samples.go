@@ -0,0 +1,120 @@
+package main
+
+import "github.com/valyala/fasthttp"
+
+// Fixed documents served by /html, /xml, /json and /encoding/utf8, shaped
+// after httpbin's samples
+const (
+	sampleHTML = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>Herman Melville - Moby-Dick</title>
+  </head>
+  <body>
+    <h1>Herman Melville - Moby-Dick</h1>
+    <div>
+      <p>
+        Availing himself of the mild, summer-cool weather that now reigned in these latitudes, and in preparation for the peculiarly active pursuits shortly to be anticipated, Perth, the begrimed, blistered old blacksmith, had not removed his portable forge to the hold again, after concluding his contributory work for Ahab's leg, but still retained it on deck, fast lashed to ringbolts by the foremast.
+      </p>
+    </div>
+  </body>
+</html>
+`
+
+	sampleXML = `<?xml version='1.0' encoding='us-ascii'?>
+
+<!--  A SAMPLE set of slides  -->
+
+<slideshow 
+    title="Sample Slide Show"
+    date="Date of publication"
+    author="Yours Truly"
+    >
+
+    <!-- TITLE SLIDE -->
+    <slide type="all">
+      <title>Wake up to WonderWidgets!</title>
+    </slide>
+
+    <!-- OVERVIEW -->
+    <slide type="all">
+        <title>Overview</title>
+        <item>Why <em>WonderWidgets</em> are great</item>
+        <item/>
+        <item>Who <em>buys</em> WonderWidgets</item>
+    </slide>
+
+</slideshow>
+`
+
+	sampleJSON = `{
+  "slideshow": {
+    "author": "Yours Truly",
+    "date": "date of publication",
+    "slides": [
+      {
+        "title": "Wake up to WonderWidgets!",
+        "type": "all"
+      },
+      {
+        "items": [
+          "Why <em>WonderWidgets</em> are great",
+          "Who <em>buys</em> WonderWidgets"
+        ],
+        "title": "Overview",
+        "type": "all"
+      }
+    ],
+    "title": "Sample Slide Show"
+  }
+}
+`
+
+	sampleUTF8 = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>UTF-8 encoded sample</title>
+</head>
+<body>
+<pre>
+Mathematics and sciences:
+
+  ∮ E⋅da = Q,  n → ∞, ∑ f(i) = ∏ g(i), ∀x∈ℝ: ⌈x⌉ = −⌊−x⌋, α ∧ ¬β = ¬(¬α ∨ β)
+
+Linguistics and dictionaries:
+
+  ði ıntəˈnæʃənəl fəˈnɛtık əsoʊsiˈeıʃn
+  Y [ˈʏpsilɔn], Yen [jɛn], Yoga [ˈjoːgɑ]
+
+Greek:    Σὲ γνωρίζω ἀπὸ τὴν κόψη τοῦ σπαθιοῦ τὴν τρομερή
+Russian:  Зарегистрируйтесь сейчас на Десятую Международную Конференцию
+Thai:     ๏ แผ่นดินฮั่นเสื่อมโทรมแสนสังเวช
+Amharic:  ሰማይ አይታረስ ንጉሥ አይከሰስ።
+Runes:    ᚻᛖ ᚳᚹᚫᚦ ᚦᚫᛏ ᚻᛖ ᛒᚢᛞᛖ ᚩᚾ ᚦᚫᛗ ᛚᚪᚾᛞᛖ ᚾᚩᚱᚦᚹᛖᚪᚱᛞᚢᛗ ᚹᛁᚦ ᚦᚪ ᚹᛖᛥᚫ
+Braille:  ⡌⠁⠧⠑ ⠼⠁⠒  ⡍⠜⠇⠑⠹⠰⠎ ⡣⠕⠌
+Chinese:  我能吞下玻璃而不伤身体。
+Japanese: 私はガラスを食べられます。それは私を傷つけません。
+Emoji:    😀 🚀 🧪 👩🏽‍💻 🏳️‍🌈
+</pre>
+</body>
+</html>
+`
+)
+
+// sampleHandler returns a handler serving a fixed document with the given
+// Content-Type
+func sampleHandler(contentType, body string) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType(contentType)
+		ctx.SetBodyString(body)
+	}
+}
+
+var (
+	htmlHandler = sampleHandler("text/html; charset=utf-8", sampleHTML)
+	xmlHandler  = sampleHandler("application/xml", sampleXML)
+	jsonHandler = sampleHandler("application/json", sampleJSON)
+	utf8Handler = sampleHandler("text/html; charset=utf-8", sampleUTF8)
+)
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const maxShards = 65536
+
+var errInvalidShardBy = errors.New("shard_by must be header:<name>, cookie:<name>, query:<name>, path or ip")
+
+// shardKey extracts the sharding key of the request named by shard_by
+func shardKey(ctx *fasthttp.RequestCtx, by string) ([]byte, error) {
+	source, name := by, ""
+	if i := strings.IndexByte(by, ':'); i >= 0 {
+		source, name = by[:i], by[i+1:]
+	}
+
+	switch {
+	case source == "header" && name != "":
+		return ctx.Request.Header.Peek(name), nil
+	case source == "cookie" && name != "":
+		return ctx.Request.Header.Cookie(name), nil
+	case source == "query" && name != "":
+		return ctx.QueryArgs().Peek(name), nil
+	case source == "path" && name == "":
+		return ctx.Path(), nil
+	case source == "ip" && name == "":
+		return []byte(ctx.RemoteIP().String()), nil
+	}
+	return nil, errInvalidShardBy
+}
+
+// shardHash is the 32-bit FNV-1a fingerprint of a sharding key, it's
+// stable across restarts and builds so clients can compute it too
+func shardHash(key []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32()
+}
+
+// withSharding maps requests carrying ?shard_by=&shards= to a shard by the
+// FNV-1a hash of their key and reports it in X-Shard, X-Shard-Key-Hash and
+// X-Shard-Count. shard_latency=d delays shard i by i*d, up to maxDelay for
+// the last one, and shard_error_rate=r fails shard i with probability
+// r*i/(shards-1) with a 503, so behavior varies along a curve over the
+// shards.
+func withSharding(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		args := ctx.QueryArgs()
+		by := args.Peek("shard_by")
+		if len(by) == 0 {
+			h(ctx)
+			return
+		}
+
+		shards, err := intArg(args, "shards", 16)
+		if err != nil || shards < 1 || shards > maxShards {
+			ctx.Error("shards must be between 1 and "+strconv.Itoa(maxShards), fasthttp.StatusBadRequest)
+			return
+		}
+		key, err := shardKey(ctx, b2s(by))
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		// The last shard waits the longest, it's held to maxDelay like
+		// every other delay
+		latency, err := millisArg(args, "shard_latency")
+		if err != nil || time.Duration(shards-1)*latency > maxDelay {
+			ctx.Error("shard_latency must be milliseconds or a duration, up to "+maxDelay.String()+" for the last shard", fasthttp.StatusBadRequest)
+			return
+		}
+		var errorRate float64
+		if v := args.Peek("shard_error_rate"); len(v) > 0 {
+			if errorRate, err = strconv.ParseFloat(b2s(v), 64); err != nil || errorRate < 0 || errorRate > 1 {
+				ctx.Error("shard_error_rate must be between 0 and 1", fasthttp.StatusBadRequest)
+				return
+			}
+		}
+
+		hash := shardHash(key)
+		shard := int(hash % uint32(shards))
+
+		if !sleepRequest(ctx, time.Duration(shard)*latency) {
+			return
+		}
+		if errorRate > 0 && shards > 1 && rand.Float64() < errorRate*float64(shard)/float64(shards-1) {
+			ctx.Error("shard "+strconv.Itoa(shard)+" failure", fasthttp.StatusServiceUnavailable)
+		} else {
+			h(ctx)
+		}
+
+		ctx.Response.Header.Set("X-Shard", strconv.Itoa(shard))
+		ctx.Response.Header.Set("X-Shard-Count", strconv.Itoa(shards))
+		ctx.Response.Header.Set("X-Shard-Key-Hash", strconv.FormatUint(uint64(hash), 16))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// peerPollInterval is how often a sleeping request checks whether its
+// client has gone away
+const peerPollInterval = 100 * time.Millisecond
+
+// draining is closed once shutdown starts so that sleeping requests and
+// streams give up instead of holding up the drain
+var draining = make(chan struct{})
+
+// sleepRequest waits d unless the client disconnects or the server starts
+// draining first, it reports whether the full duration passed. A 503 is
+// set when the wait was cut short, a gone client never sees it.
+func sleepRequest(ctx *fasthttp.RequestCtx, d time.Duration) bool {
+	if sleepConn(ctx.Conn(), d) {
+		return true
+	}
+	ctx.Error("request aborted", fasthttp.StatusServiceUnavailable)
+	return false
+}
+
+func sleepConn(c net.Conn, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	poll := time.NewTicker(peerPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case <-draining:
+			return false
+		case <-poll.C:
+			if peerClosed(c) {
+				return false
+			}
+		}
+	}
+}
+
+// sleepStream waits d unless the server starts draining first, for body
+// stream writers which notice gone clients by their failing writes
+func sleepStream(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-draining:
+		return false
+	}
+}
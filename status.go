@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errInvalidStatus = errors.New("invalid status")
+
+// statusHandler serves /status/{code}, httpbin's weighted random choice
+// /status/{code}:{weight},{code}:{weight} and the uniform choice
+// /status/random?codes={code},{code}. 429 and 503 responses carry a
+// Retry-After when ?retry_after= is given, in seconds or, with
+// ?retry_after_format=date, as the HTTP-date that many seconds ahead. An
+// HTTP-date given as retry_after is passed through verbatim.
+// ?body= sets the body, repeated or cut to ?size= bytes if given as well,
+// ?size= alone sends the /bin pattern, ?content_type= labels either.
+func statusHandler(ctx *fasthttp.RequestCtx) {
+	spec := strings.TrimPrefix(b2s(ctx.Path()), "/status/")
+	if spec == "random" {
+		// /status/random?codes= picks uniformly, weights are not allowed
+		spec = string(ctx.QueryArgs().Peek("codes"))
+		if strings.Contains(spec, ":") {
+			spec = ""
+		}
+	}
+
+	code, err := pickStatus(spec)
+	if err != nil {
+		ctx.Error("expected /status/{code}, /status/{code}:{weight},... or /status/random?codes={code},...", fasthttp.StatusBadRequest)
+		return
+	}
+
+	if code == fasthttp.StatusTooManyRequests || code == fasthttp.StatusServiceUnavailable {
+		if v := ctx.QueryArgs().Peek("retry_after"); len(v) > 0 {
+			retryAfter, err := retryAfterValue(b2s(v), b2s(ctx.QueryArgs().Peek("retry_after_format")))
+			if err != nil {
+				ctx.Error("retry_after must be seconds or an HTTP-date", fasthttp.StatusBadRequest)
+				return
+			}
+			ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, retryAfter)
+		}
+	}
+
+	ctx.SetStatusCode(code)
+
+	args := ctx.QueryArgs()
+	body := string(args.Peek("body"))
+	size := int64(len(body))
+	if v := args.Peek("size"); len(v) > 0 {
+		if size, err = parseSize(b2s(v)); err != nil {
+			ctx.Error("size must be a byte count, e.g. 512 or 64K", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	if size == 0 {
+		return
+	}
+	if body == "" {
+		body = pattern
+	}
+
+	contentType := "text/plain; charset=utf-8"
+	if v := args.Peek("content_type"); len(v) > 0 {
+		if !validHeaderValue(v) {
+			ctx.Error("content_type must be free of control characters", fasthttp.StatusBadRequest)
+			return
+		}
+		contentType = string(v)
+	}
+	ctx.SetContentType(contentType)
+	ctx.SetBodyStream(&patternReader{pattern: body, n: size}, int(size))
+}
+
+// pickStatus parses a status code list and picks one by weight, codes
+// without a weight count as 1
+func pickStatus(spec string) (int, error) {
+	type choice struct {
+		code   int
+		weight float64
+	}
+
+	var choices []choice
+	var total float64
+	for _, part := range strings.Split(spec, ",") {
+		codeStr, weightStr, hasWeight := strings.Cut(part, ":")
+		code, err := strconv.Atoi(codeStr)
+		// A 1xx can't be the final response
+		if err != nil || code < 200 || code > 599 {
+			return 0, errInvalidStatus
+		}
+		weight := 1.0
+		if hasWeight {
+			if weight, err = strconv.ParseFloat(weightStr, 64); err != nil || weight < 0 {
+				return 0, errInvalidStatus
+			}
+		}
+		choices = append(choices, choice{code, weight})
+		total += weight
+	}
+	if total <= 0 {
+		return 0, errInvalidStatus
+	}
+
+	r := rand.Float64() * total
+	for _, c := range choices {
+		if r < c.weight {
+			return c.code, nil
+		}
+		r -= c.weight
+	}
+	return choices[len(choices)-1].code, nil
+}
+
+// retryAfterValue builds a Retry-After value from seconds, formatted as
+// delta-seconds or as an HTTP-date, or validates a given HTTP-date
+func retryAfterValue(value, format string) (string, error) {
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		if _, err := fasthttp.ParseHTTPDate([]byte(value)); err != nil {
+			return "", err
+		}
+		return value, nil
+	}
+	if seconds < 0 {
+		return "", errInvalidStatus
+	}
+
+	if format == "date" {
+		return string(fasthttp.AppendHTTPDate(nil, serverNow().Add(time.Duration(seconds)*time.Second))), nil
+	}
+	return strconv.Itoa(seconds), nil
+}
+
+const statusHeader = "X-HPDummy-Status"
+
+// withStatusHeader replaces the status of JSON responses with the code in
+// the X-HPDummy-Status request header, so mirrored traffic can be forced
+// into errors without rewriting URLs
+func withStatusHeader(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		v := ctx.Request.Header.Peek(statusHeader)
+		if len(v) == 0 {
+			h(ctx)
+			return
+		}
+
+		code, err := strconv.Atoi(b2s(v))
+		if err != nil || code < 200 || code > 599 {
+			ctx.Error(statusHeader+" must be a status code between 200 and 599", fasthttp.StatusBadRequest)
+			return
+		}
+
+		h(ctx)
+
+		if bytes.HasPrefix(ctx.Response.Header.ContentType(), []byte("application/json")) {
+			ctx.SetStatusCode(code)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// maxStreamLines bounds /stream/{n}
+const maxStreamLines = 100000
+
+// streamLine is a single object of the /stream/{n} response
+type streamLine struct {
+	ID      int                    `json:"id"`
+	Time    time.Time              `json:"time"`
+	URL     string                 `json:"url"`
+	Args    map[string]interface{} `json:"args"`
+	Headers map[string]string      `json:"headers"`
+	Origin  string                 `json:"origin"`
+}
+
+// streamHandler serves /stream/{n}?delay=, n newline-delimited JSON objects
+// each flushed on its own, with an optional delay before every line after
+// the first
+func streamHandler(ctx *fasthttp.RequestCtx) {
+	n, err := strconv.Atoi(strings.TrimPrefix(b2s(ctx.Path()), "/stream/"))
+	if err != nil || n < 0 || n > maxStreamLines {
+		ctx.Error("expected /stream/{n} with 0 <= n <= "+strconv.Itoa(maxStreamLines), fasthttp.StatusBadRequest)
+		return
+	}
+
+	var delay time.Duration
+	if d := ctx.QueryArgs().Peek("delay"); len(d) > 0 {
+		delay, err = time.ParseDuration(b2s(d))
+		if err != nil || delay < 0 {
+			ctx.Error("delay must be a non-negative duration, e.g. 100ms", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	// The request is not available anymore once the body is streamed
+	line := &streamLine{
+		URL:     string(ctx.URI().FullURI()),
+		Args:    argsToMap(ctx.QueryArgs()),
+		Headers: make(map[string]string),
+		Origin:  ctx.RemoteIP().String(),
+	}
+	ctx.Request.Header.VisitAll(func(k, v []byte) {
+		line.Headers[string(k)] = string(v)
+	})
+
+	ctx.SetContentType("application/x-ndjson")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		enc := json.NewEncoder(w)
+		for i := 0; i < n; i++ {
+			if i > 0 && !sleepStream(delay) {
+				return
+			}
+
+			line.ID = i
+			line.Time = time.Now()
+			if err := enc.Encode(line); err != nil {
+				return
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
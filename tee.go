@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"expvar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// teeDir is where ?tee=true copies of responses are written, empty
+// disables teeing; bodies above teeMaxBytes are not captured
+var (
+	teeDir      string
+	teeMaxBytes int64
+)
+
+var (
+	teeWritten = expvar.NewInt("tee_written")
+	teeErrors  = expvar.NewInt("tee_errors")
+)
+
+// withTee writes the status line, headers and body of responses to
+// requests with ?tee=true to a file in teeDir named after the request's
+// X-Request-Id. The file name is returned in X-Tee-File.
+func withTee(h fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		h(ctx)
+
+		if teeDir == "" || !ctx.QueryArgs().GetBool("tee") {
+			return
+		}
+
+		name := teeFileName(b2s(ctx.Request.Header.Peek(requestIDHeader)))
+		if err := writeTee(filepath.Join(teeDir, name), &ctx.Response); err != nil {
+			teeErrors.Add(1)
+			logf(componentTee, "error writing file %s: %v", name, err)
+			return
+		}
+		teeWritten.Add(1)
+		ctx.Response.Header.Set("X-Tee-File", name)
+	}
+}
+
+// writeTee dumps resp to path. Streamed bodies of a known length within
+// teeMaxBytes are read into memory first so they can be both written and
+// sent, other streamed or oversized bodies are left out of the file.
+func writeTee(path string, resp *fasthttp.Response) error {
+	var buf bytes.Buffer
+	buf.Write(resp.Header.Header())
+
+	size := int64(resp.Header.ContentLength())
+	switch {
+	case resp.IsBodyStream() && size >= 0 && size <= teeMaxBytes:
+		var body bytes.Buffer
+		if err := resp.BodyWriteTo(&body); err != nil {
+			return err
+		}
+		resp.SetBody(body.Bytes())
+		buf.Write(body.Bytes())
+	case resp.IsBodyStream():
+		fmt.Fprintf(&buf, "[streamed body of %d bytes not captured]\n", size)
+	case int64(len(resp.Body())) > teeMaxBytes:
+		fmt.Fprintf(&buf, "[body of %d bytes above -tee-max-bytes not captured]\n", len(resp.Body()))
+	default:
+		buf.Write(resp.Body())
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+// teeFileName builds a file name from the request ID, keeping only
+// characters safe in a path. The timestamp keeps retries with the same ID
+// from overwriting each other.
+func teeFileName(reqID string) string {
+	safe := make([]byte, 0, len(reqID))
+	for i := 0; i < len(reqID) && len(safe) < 64; i++ {
+		c := reqID[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' {
+			safe = append(safe, c)
+		}
+	}
+	if len(safe) == 0 || safe[0] == '.' {
+		safe = append([]byte("req"), safe...)
+	}
+	return string(safe) + "-" + strconv.FormatInt(time.Now().UnixNano(), 10) + ".http"
+}
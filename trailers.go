@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// maxTrailers bounds the trailers a response can be asked for
+const maxTrailers = 32
+
+var errInvalidTrailers = errors.New("trailers must be a comma separated list of name:value, up to 32")
+
+// trailer is an HTTP trailer field sent after the last chunk
+type trailer struct {
+	name, value string
+}
+
+// parseTrailers parses ?trailers=k1:v1,k2:v2, names must be header tokens
+// and values free of control characters
+func parseTrailers(s string) ([]trailer, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var trailers []trailer
+	for _, field := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(field, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || !isToken(name) || !validHeaderValue([]byte(value)) || len(trailers) == maxTrailers {
+			return nil, errInvalidTrailers
+		}
+		trailers = append(trailers, trailer{name: name, value: value})
+	}
+	return trailers, nil
+}
+
+// isToken reports whether s is a non-empty RFC 9110 token
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// declareTrailers lists the trailer names for the Trailer header
+func declareTrailers(trailers []trailer) string {
+	names := make([]string, len(trailers))
+	for i, t := range trailers {
+		names[i] = t.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// appendTrailers appends the last chunk followed by the trailer fields
+func appendTrailers(dst []byte, trailers []trailer) []byte {
+	dst = append(dst, "0\r\n"...)
+	for _, t := range trailers {
+		dst = append(dst, t.name...)
+		dst = append(dst, ": "...)
+		dst = append(dst, t.value...)
+		dst = append(dst, "\r\n"...)
+	}
+	return append(dst, "\r\n"...)
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"hash"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// uploadPart describes one part of a multipart upload, its content is
+// discarded
+type uploadPart struct {
+	Name        string `json:"name"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+}
+
+var uploadResets = expvar.NewInt("upload_resets")
+
+// uploadMax is the largest body /upload reads unless ?max= is given, 0
+// means unlimited
+var uploadMax atomicInt64
+
+var errUploadTooLarge = errors.New("upload too large")
+
+// uploadHashes are the digests ?checksum= can compute over uploads
+var uploadHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"md5":    md5.New,
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+}
+
+// countingReader counts the bytes and the reads that returned any
+// through it, and when the first byte arrived. The bytes are also added to
+// progress when set.
+type countingReader struct {
+	r         io.Reader
+	n         int64
+	chunks    int64
+	firstByte time.Time
+	progress  *uploadProgress
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		if c.progress != nil {
+			c.progress.add(n)
+		}
+		if c.chunks == 0 {
+			c.firstByte = time.Now()
+		}
+		c.chunks++
+		c.n += int64(n)
+	}
+	return n, err
+}
+
+// throttledReader reads at most rate bytes per second from r, in slices
+// of a tenth of a second so the pace is even
+type throttledReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	n     int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	if slice := t.rate/10 + 1; int64(len(p)) > slice {
+		p = p[:slice]
+	}
+
+	// Wait until the bytes read so far are due at the rate
+	due := t.start.Add(time.Duration(float64(t.n) / float64(t.rate) * float64(time.Second)))
+	if !sleepStream(time.Until(due)) {
+		return 0, errAborted
+	}
+
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	return n, err
+}
+
+// limitedReader reads up to left bytes from r and then fails with
+// errUploadTooLarge if r has more
+type limitedReader struct {
+	r        io.Reader
+	left     int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		if n > 0 {
+			l.exceeded = true
+			return 0, errUploadTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	return n, err
+}
+
+// maxBufferedBody bounds the request bodies of handlers that read them
+// whole. Request bodies are streamed for the upload endpoints, so
+// fasthttp's own MaxRequestBodySize doesn't apply and bufferRequestBody
+// enforces its default instead.
+const maxBufferedBody = fasthttp.DefaultMaxRequestBodySize
+
+// streamsRequestBody reports whether the handler of path streams the
+// request body instead of reading it whole
+func streamsRequestBody(path string) bool {
+	switch path {
+	case "/upload", "/upload/echo", "/put", "/patch", "/delete":
+		return true
+	}
+	return false
+}
+
+// bufferRequestBody reads a streamed request body into the request, so
+// Body() and the form parsers see it. It answers 413 and closes the
+// connection for bodies above maxBufferedBody and reports false.
+func bufferRequestBody(ctx *fasthttp.RequestCtx) bool {
+	r := ctx.RequestBodyStream()
+	if r == nil {
+		return true
+	}
+
+	tooLarge := ctx.Request.Header.ContentLength() > maxBufferedBody
+	var body []byte
+	if !tooLarge {
+		var err error
+		if body, err = io.ReadAll(io.LimitReader(r, maxBufferedBody+1)); err != nil {
+			ctx.Error("error reading body: "+err.Error(), fasthttp.StatusBadRequest)
+			return false
+		}
+		tooLarge = len(body) > maxBufferedBody
+	}
+	if tooLarge {
+		ctx.Error("request body too large", fasthttp.StatusRequestEntityTooLarge)
+		ctx.SetConnectionClose()
+		return false
+	}
+	ctx.Request.SetBody(body)
+	return true
+}
+
+// requestBody returns the request body as a reader, streamed from the
+// connection when fasthttp didn't already buffer it
+func requestBody(ctx *fasthttp.RequestCtx) io.Reader {
+	if r := ctx.RequestBodyStream(); r != nil {
+		return r
+	}
+	return bytes.NewReader(ctx.Request.Body())
+}
+
+// uploadHandler serves /upload, it reads and discards the request body and
+// reports its size. multipart/form-data bodies are parsed part by part as
+// they stream in and every part is reported with its name, filename,
+// content type and size, nothing is buffered. ?checksum=sha256|md5|crc32
+// adds the hex digest of the raw body. ?read_rate=1M reads the body at
+// most that many bytes per second, pushing back on the client.
+// ?continue=413 refuses the upload without reading the body, with 417 when
+// it was sent with Expect: 100-continue. ?max= or -upload-max stops
+// reading past a size and answers 413. The timing of
+// the read is reported so it can be compared with the client's view,
+// and so are the trailers of chunked uploads. ?progress_id= makes the
+// bytes received so far visible at /upload/progress/{id}. ?reset_after=
+// resets the connection once that many body bytes are read.
+// ?decompress=true decodes a gzip, deflate, br or zstd Content-Encoding,
+// up to ?decompress_max=, and reports the decompressed size too.
+func uploadHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() && !ctx.IsPut() {
+		ctx.Error("upload with POST or PUT", fasthttp.StatusMethodNotAllowed)
+		ctx.Response.Header.Set(fasthttp.HeaderAllow, "POST, PUT")
+		return
+	}
+
+	// Refuse before reading any of the body, the connection can't be
+	// reused with the body still on the way. Requests expecting 100
+	// Continue were already refused with 417 by continueHandler.
+	if string(ctx.QueryArgs().Peek("continue")) == "413" {
+		ctx.SetConnectionClose()
+		writeJSON(ctx, fasthttp.StatusRequestEntityTooLarge, map[string]interface{}{
+			"error":          "upload refused",
+			"content_length": ctx.Request.Header.ContentLength(),
+		})
+		return
+	}
+
+	start := time.Now()
+	headers := requestHeaderSet(&ctx.Request.Header)
+	body := &countingReader{r: requestBody(ctx)}
+	if id := string(ctx.QueryArgs().Peek("progress_id")); id != "" {
+		if !validProgressID(id) {
+			ctx.Error("progress_id must be a token of up to 128 characters", fasthttp.StatusBadRequest)
+			return
+		}
+		body.progress = startProgress(id, int64(ctx.Request.Header.ContentLength()))
+		defer body.progress.finish()
+	}
+	if v := ctx.QueryArgs().Peek("read_rate"); len(v) > 0 {
+		rate, err := parseSize(b2s(v))
+		if err != nil || rate < 1 {
+			ctx.Error("read_rate must be bytes per second, e.g. 64K or 1M", fasthttp.StatusBadRequest)
+			return
+		}
+		body.r = &throttledReader{r: body.r, rate: rate}
+	}
+	if v := ctx.QueryArgs().Peek("reset_after"); len(v) > 0 {
+		n, err := parseSize(b2s(v))
+		if err != nil {
+			ctx.Error("reset_after must be a byte count, e.g. 512 or 64K", fasthttp.StatusBadRequest)
+			return
+		}
+		io.CopyN(io.Discard, body, n)
+		uploadResets.Add(1)
+		logf(componentHTTP, "/upload reset %s after %d bytes", ctx.RemoteAddr(), body.n)
+
+		// Hijacked so no response is written to the reset connection,
+		// which is wrapped there, SO_LINGER needs the TCP one
+		raw := ctx.Conn()
+		ctx.HijackSetNoResponse(true)
+		ctx.Hijack(func(net.Conn) {
+			resetConn(raw)
+		})
+		return
+	}
+	var h hash.Hash
+	algorithm := string(ctx.QueryArgs().Peek("checksum"))
+	if algorithm != "" {
+		newHash, ok := uploadHashes[algorithm]
+		if !ok {
+			ctx.Error("checksum must be sha256, md5 or crc32", fasthttp.StatusBadRequest)
+			return
+		}
+		h = newHash()
+		body.r = io.TeeReader(body.r, h)
+	}
+
+	limit := uploadMax.Get()
+	if v := ctx.QueryArgs().Peek("max"); len(v) > 0 {
+		var err error
+		if limit, err = parseSize(b2s(v)); err != nil {
+			ctx.Error("max must be a byte count, e.g. 64K or 10M", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+	in := io.Reader(body)
+	limited := &limitedReader{r: body, left: limit}
+	if limit > 0 {
+		in = limited
+	}
+
+	contentType := string(ctx.Request.Header.ContentType())
+	result := map[string]interface{}{
+		"content_type": contentType,
+	}
+
+	var decoded *countingReader
+	decompressMax := int64(defaultDecompressMax)
+	decodedLimited := &limitedReader{}
+	if ctx.QueryArgs().GetBool("decompress") {
+		if v := ctx.QueryArgs().Peek("decompress_max"); len(v) > 0 {
+			var err error
+			if decompressMax, err = parseSize(b2s(v)); err != nil {
+				ctx.Error("decompress_max must be a byte count, e.g. 64K or 10M", fasthttp.StatusBadRequest)
+				return
+			}
+		}
+		coding := string(ctx.Request.Header.Peek(fasthttp.HeaderContentEncoding))
+		dec, err := newUploadDecoder(coding, in)
+		if err == errUnsupportedEncoding {
+			ctx.Error(err.Error(), fasthttp.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			ctx.Error("malformed "+coding+" body: "+err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		defer dec.Close()
+
+		decoded = &countingReader{r: dec}
+		decodedLimited.r, decodedLimited.left = decoded, decompressMax
+		in = decodedLimited
+		result["content_encoding"] = coding
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if mediaType == "multipart/form-data" && params["boundary"] != "" {
+		parts, err := readUploadParts(multipart.NewReader(in, params["boundary"]))
+		if limited.exceeded {
+			uploadTooLarge(ctx, limit, body.n)
+			return
+		}
+		if decodedLimited.exceeded {
+			decompressedTooLarge(ctx, decompressMax, decoded.n)
+			return
+		}
+		if err != nil {
+			ctx.Error("malformed multipart body: "+err.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		result["parts"] = parts
+	}
+
+	if _, err := io.Copy(io.Discard, in); limited.exceeded {
+		uploadTooLarge(ctx, limit, body.n)
+		return
+	} else if decodedLimited.exceeded {
+		decompressedTooLarge(ctx, decompressMax, decoded.n)
+		return
+	} else if err != nil {
+		ctx.Error("error reading body: "+err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	result["bytes"] = body.n
+	if decoded != nil {
+		result["decompressed_bytes"] = decoded.n
+	}
+	elapsed := time.Since(start)
+	result["duration_ms"] = milliseconds(elapsed)
+	result["read_chunks"] = body.chunks
+	if body.chunks > 0 {
+		result["first_byte_ms"] = milliseconds(body.firstByte.Sub(start))
+	}
+	if elapsed > 0 {
+		result["throughput_bps"] = int64(float64(body.n*8) / elapsed.Seconds())
+	}
+	if h != nil {
+		result[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	if trailers := requestTrailers(&ctx.Request.Header, headers); len(trailers) > 0 {
+		result["trailers"] = trailers
+	}
+
+	writeJSON(ctx, fasthttp.StatusOK, result)
+}
+
+// requestHeaderSet returns the header fields received so far as
+// "name: value" keys
+func requestHeaderSet(h *fasthttp.RequestHeader) map[string]bool {
+	set := make(map[string]bool)
+	h.VisitAll(func(k, v []byte) {
+		set[string(k)+": "+string(v)] = true
+	})
+	return set
+}
+
+// requestTrailers returns the trailer fields of a chunked request: those
+// that were added to the header while the body was read, and the fields
+// declared in the Trailer header of bodies fasthttp buffered up front
+func requestTrailers(h *fasthttp.RequestHeader, before map[string]bool) map[string]string {
+	trailers := make(map[string]string)
+	h.VisitAll(func(k, v []byte) {
+		if !before[string(k)+": "+string(v)] {
+			trailers[string(k)] = string(v)
+		}
+	})
+	for _, name := range strings.Split(string(h.Peek(fasthttp.HeaderTrailer)), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if v := h.Peek(name); len(v) > 0 {
+				trailers[name] = string(v)
+			}
+		}
+	}
+	return trailers
+}
+
+// milliseconds converts d to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// uploadTooLarge rejects an upload that went past limit after read bytes,
+// the rest of the body is left unread so the connection is closed
+func uploadTooLarge(ctx *fasthttp.RequestCtx, limit, read int64) {
+	ctx.SetConnectionClose()
+	writeJSON(ctx, fasthttp.StatusRequestEntityTooLarge, map[string]interface{}{
+		"error":      errUploadTooLarge.Error(),
+		"max":        limit,
+		"bytes_read": read,
+	})
+}
+
+// decompressedTooLarge rejects an upload that decompressed to more than
+// limit after read decompressed bytes
+func decompressedTooLarge(ctx *fasthttp.RequestCtx, limit, read int64) {
+	ctx.SetConnectionClose()
+	writeJSON(ctx, fasthttp.StatusRequestEntityTooLarge, map[string]interface{}{
+		"error":                   "decompressed upload too large",
+		"decompress_max":          limit,
+		"decompressed_bytes_read": read,
+	})
+}
+
+// readUploadParts drains every part of a multipart body
+func readUploadParts(mr *multipart.Reader) ([]*uploadPart, error) {
+	parts := []*uploadPart{}
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		part := &uploadPart{
+			Name:        p.FormName(),
+			Filename:    p.FileName(),
+			ContentType: strings.TrimSpace(p.Header.Get("Content-Type")),
+		}
+		part.Size, err = io.Copy(io.Discard, p)
+		p.Close()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+}
+
+// methodUploadHandler serves /put, /patch and /delete for REST clients,
+// each takes a body with any of PUT, PATCH or DELETE and reports the
+// method and the size of the body, which is streamed and discarded
+func methodUploadHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPut() && !ctx.IsPatch() && !ctx.IsDelete() {
+		ctx.Error("use PUT, PATCH or DELETE", fasthttp.StatusMethodNotAllowed)
+		ctx.Response.Header.Set(fasthttp.HeaderAllow, "PUT, PATCH, DELETE")
+		return
+	}
+
+	n, err := io.Copy(io.Discard, requestBody(ctx))
+	if err != nil {
+		ctx.Error("error reading body: "+err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	writeJSON(ctx, fasthttp.StatusOK, map[string]interface{}{
+		"method":       string(ctx.Method()),
+		"content_type": string(ctx.Request.Header.ContentType()),
+		"bytes":        n,
+	})
+}
+
+// uploadEchoHandler serves /upload/echo, it streams the request body back
+// as the response body with the same Content-Type while it's still being
+// received, so large bodies can be compared end to end without either side
+// buffering them. Clients must read the response while they send.
+func uploadEchoHandler(ctx *fasthttp.RequestCtx) {
+	if !ctx.IsPost() && !ctx.IsPut() {
+		ctx.Error("upload with POST or PUT", fasthttp.StatusMethodNotAllowed)
+		ctx.Response.Header.Set(fasthttp.HeaderAllow, "POST, PUT")
+		return
+	}
+
+	if contentType := ctx.Request.Header.ContentType(); len(contentType) > 0 {
+		ctx.Response.Header.SetContentTypeBytes(contentType)
+	} else {
+		ctx.SetContentType("application/octet-stream")
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+
+	// The request stream stays readable until the response is written, a
+	// chunked upload (-1) is mirrored chunked
+	ctx.SetBodyStream(requestBody(ctx), ctx.Request.Header.ContentLength())
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// uploadResult decodes the fields of the /upload report the tests check
+type uploadResult struct {
+	Bytes    int64             `json:"bytes"`
+	SHA256   string            `json:"sha256"`
+	Parts    []uploadPart      `json:"parts"`
+	Trailers map[string]string `json:"trailers"`
+}
+
+func decodeUpload(t *testing.T, resp *http.Response, body []byte) uploadResult {
+	t.Helper()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", resp.StatusCode, body)
+	}
+	var r uploadResult
+	if err := json.Unmarshal(body, &r); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	return r
+}
+
+func TestUploadReportsSizeAndChecksum(t *testing.T) {
+	ln := serveTest(t)
+
+	payload := bytes.Repeat([]byte("0123456789"), 100<<10)
+	resp, body := roundTrip(t, ln, newRequest(t, "POST", "/upload?checksum=sha256", bytes.NewReader(payload)))
+	r := decodeUpload(t, resp, body)
+
+	if r.Bytes != int64(len(payload)) {
+		t.Errorf("bytes = %d, want %d", r.Bytes, len(payload))
+	}
+	sum := sha256.Sum256(payload)
+	if want := hex.EncodeToString(sum[:]); r.SHA256 != want {
+		t.Errorf("sha256 = %s, want %s", r.SHA256, want)
+	}
+}
+
+func TestUploadChunkedWithTrailers(t *testing.T) {
+	ln := serveTest(t)
+
+	req := newRequest(t, "PUT", "/upload", io.MultiReader(strings.NewReader("hello "), strings.NewReader("world")))
+	req.ContentLength = -1
+	req.Trailer = http.Header{"X-Upload-Done": {"yes"}}
+	resp, body := roundTrip(t, ln, req)
+	r := decodeUpload(t, resp, body)
+
+	if r.Bytes != 11 {
+		t.Errorf("bytes = %d, want 11", r.Bytes)
+	}
+	if got := r.Trailers["X-Upload-Done"]; got != "yes" {
+		t.Errorf("trailer X-Upload-Done = %q, want yes (trailers %v)", got, r.Trailers)
+	}
+}
+
+func TestUploadMultipartParts(t *testing.T) {
+	ln := serveTest(t)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("title", "report")
+	fw, _ := mw.CreateFormFile("file", "data.bin")
+	fw.Write(make([]byte, 4096))
+	mw.Close()
+
+	req := newRequest(t, "POST", "/upload", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, body := roundTrip(t, ln, req)
+	r := decodeUpload(t, resp, body)
+
+	want := []uploadPart{
+		{Name: "title", Size: 6},
+		{Name: "file", Filename: "data.bin", ContentType: "application/octet-stream", Size: 4096},
+	}
+	if len(r.Parts) != len(want) {
+		t.Fatalf("parts = %+v, want %+v", r.Parts, want)
+	}
+	for i := range want {
+		if r.Parts[i] != want[i] {
+			t.Errorf("part %d = %+v, want %+v", i, r.Parts[i], want[i])
+		}
+	}
+}
+
+func TestUploadMax(t *testing.T) {
+	ln := serveTest(t)
+
+	resp, body := roundTrip(t, ln, newRequest(t, "POST", "/upload?max=1K", bytes.NewReader(make([]byte, 4096))))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413: %s", resp.StatusCode, body)
+	}
+	if !resp.Close {
+		t.Error("connection not closed after refusing the rest of the body")
+	}
+}
+
+func TestUploadContinueRefused(t *testing.T) {
+	ln := serveTest(t)
+
+	for _, tc := range []struct {
+		expect string
+		want   int
+	}{
+		{"", http.StatusRequestEntityTooLarge},
+		{"100-continue", http.StatusExpectationFailed},
+	} {
+		req := newRequest(t, "POST", "/upload?continue=413", strings.NewReader("body"))
+		if tc.expect != "" {
+			req.Header.Set("Expect", tc.expect)
+		}
+		resp, _ := roundTrip(t, ln, req)
+		if resp.StatusCode != tc.want {
+			t.Errorf("Expect %q: status = %d, want %d", tc.expect, resp.StatusCode, tc.want)
+		}
+	}
+}
+
+func TestUploadMethodNotAllowed(t *testing.T) {
+	ln := serveTest(t)
+
+	resp, _ := roundTrip(t, ln, newRequest(t, "GET", "/upload", nil))
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want 405", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Allow"); got != "POST, PUT" {
+		t.Errorf("Allow = %q, want %q", got, "POST, PUT")
+	}
+}
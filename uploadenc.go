@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultDecompressMax bounds decompressed uploads unless
+// ?decompress_max= is given, so a small bomb can't keep the server busy
+const defaultDecompressMax = 1 << 30
+
+var errUnsupportedEncoding = errors.New("Content-Encoding must be gzip, deflate, br, zstd or identity")
+
+// newUploadDecoder returns a reader decompressing r according to the
+// request's Content-Encoding
+func newUploadDecoder(coding string, r io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(coding)) {
+	case "", "identity":
+		return io.NopCloser(r), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		return flate.NewReader(r), nil
+	case "br":
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case "zstd":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, errUnsupportedEncoding
+}
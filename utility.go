@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// uuidHandler serves /uuid, a fresh random UUIDv4 as httpbin does
+func uuidHandler(ctx *fasthttp.RequestCtx) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusInternalServerError)
+		return
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+
+	writeJSON(ctx, fasthttp.StatusOK, map[string]string{
+		"uuid": fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]),
+	})
+}
+
+// base64Handler serves /base64/{value}, the URL-safe base64 value decoded.
+// Padding is optional and standard alphabet input is accepted as well.
+func base64Handler(ctx *fasthttp.RequestCtx) {
+	value := strings.TrimPrefix(b2s(ctx.Path()), "/base64/")
+	value = strings.NewReplacer("+", "-", "/", "_").Replace(strings.TrimRight(value, "="))
+
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		ctx.Error("incorrect base64 data, try: SFRUUEJJTiBpcyBhd2Vzb21l", fasthttp.StatusBadRequest)
+		return
+	}
+
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBody(decoded)
+}
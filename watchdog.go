@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// watchdogWarmup is the number of samples before drift is judged
+	watchdogWarmup = 30
+
+	// A check drifts when its recent latency exceeds the long-term baseline
+	// by watchdogDriftFactor and is above watchdogDriftFloor, tiny loopback
+	// latencies are too noisy to compare
+	watchdogDriftFactor = 3
+	watchdogDriftFloor  = time.Millisecond
+
+	// watchdogErrorRate is the recent error fraction flagged as anomalous
+	watchdogErrorRate = 0.05
+
+	// Smoothing of the recent and the baseline moving averages, the
+	// baseline follows over days at a minute interval
+	watchdogRecentAlpha   = 0.2
+	watchdogBaselineAlpha = 0.001
+)
+
+// Anomaly flags reported by /info
+const (
+	anomalyFailing      = "failing"
+	anomalyLatencyDrift = "latency_drift"
+	anomalyErrorRate    = "error_rate"
+)
+
+// watchdogPaths are requested on every round
+var watchdogPaths = []string{"/health", "/bin/1K", "/anything"}
+
+// Watchdog settings, a zero interval disables it
+var (
+	watchdogInterval  time.Duration
+	watchdogTimeout   time.Duration
+	watchdogExitAfter int
+)
+
+var errUnexpectedStatus = errors.New("unexpected status code")
+
+// watchdogCheck is the running state of a single self-checked path
+type watchdogCheck struct {
+	Path             string    `json:"path"`
+	Requests         int64     `json:"requests"`
+	Failures         int64     `json:"failures"`
+	ConsecutiveFails int       `json:"consecutive_failures"`
+	LastError        string    `json:"last_error,omitempty"`
+	LastFailureAt    time.Time `json:"last_failure_at,omitempty"`
+	LastLatency      string    `json:"last_latency"`
+	RecentLatency    string    `json:"recent_latency"`
+	BaselineLatency  string    `json:"baseline_latency"`
+	RecentErrorRate  float64   `json:"recent_error_rate"`
+	Anomalies        []string  `json:"anomalies"`
+
+	recent   float64
+	baseline float64
+}
+
+// watchdogReport is the JSON view of the watchdog in /info
+type watchdogReport struct {
+	Enabled   bool             `json:"enabled"`
+	Interval  string           `json:"interval,omitempty"`
+	Rounds    int64            `json:"rounds"`
+	LastRound time.Time        `json:"last_round,omitempty"`
+	Anomalous bool             `json:"anomalous"`
+	Checks    []*watchdogCheck `json:"checks,omitempty"`
+}
+
+type watchdog struct {
+	mu        sync.Mutex
+	rounds    int64
+	lastRound time.Time
+	checks    []*watchdogCheck
+}
+
+var wd = &watchdog{}
+
+// watchdogUserAgent names the watchdog's self-requests in logs
+const watchdogUserAgent = "hpdummy-watchdog"
+
+// watchdogTokenHeader carries watchdogToken on the watchdog's requests
+const watchdogTokenHeader = "X-Watchdog-Token"
+
+// watchdogToken is drawn once per process, clients can't know it
+var watchdogToken = newWatchdogToken()
+
+func newWatchdogToken() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Fatalf("watchdog token: %v", err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// isWatchdogRequest reports whether ctx is one of the watchdog's own
+// probes, which simulated failures must leave alone or the watchdog would
+// end the process over them. A probe comes from this host and carries the
+// process' token, a User-Agent alone would let any client skip an outage.
+func isWatchdogRequest(ctx *fasthttp.RequestCtx) bool {
+	if ip := ctx.RemoteIP(); !ip.IsLoopback() && !ip.Equal(ctx.LocalIP()) {
+		return false
+	}
+	token := ctx.Request.Header.Peek(watchdogTokenHeader)
+	return subtle.ConstantTimeCompare(token, []byte(watchdogToken)) == 1
+}
+
+// run self-requests watchdogPaths every watchdogInterval until the process
+// exits. With watchdogExitAfter set the process exits once any check fails
+// that many times in a row.
+func (w *watchdog) run(addr string) {
+	w.mu.Lock()
+	for _, path := range watchdogPaths {
+		w.checks = append(w.checks, &watchdogCheck{Path: path})
+	}
+	w.mu.Unlock()
+
+	client := &fasthttp.Client{
+		Name:                watchdogUserAgent,
+		MaxIdleConnDuration: 2 * watchdogInterval,
+	}
+	base := "http://" + selfAddr(addr)
+
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, c := range w.checks {
+			start := time.Now()
+			err := watchdogRequest(client, base+c.Path)
+			latency := time.Since(start)
+
+			if fails := w.observe(c, latency, err); watchdogExitAfter > 0 && fails >= watchdogExitAfter {
+				log.Fatalf("watchdog: %s failed %d times in a row: %v", c.Path, fails, err)
+			}
+		}
+
+		w.mu.Lock()
+		w.rounds++
+		w.lastRound = time.Now()
+		w.mu.Unlock()
+	}
+}
+
+func watchdogRequest(client *fasthttp.Client, url string) error {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI(url)
+	req.Header.Set(watchdogTokenHeader, watchdogToken)
+	if err := client.DoTimeout(req, resp, watchdogTimeout); err != nil {
+		return err
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		return errUnexpectedStatus
+	}
+	return nil
+}
+
+// observe updates the check with one sample, publishes an event for every
+// newly raised anomaly and returns the consecutive failure count
+func (w *watchdog) observe(c *watchdogCheck, latency time.Duration, err error) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	c.Requests++
+	c.LastLatency = latency.String()
+
+	failed := 0.0
+	if err != nil {
+		failed = 1
+		c.Failures++
+		c.ConsecutiveFails++
+		c.LastError = err.Error()
+		c.LastFailureAt = time.Now()
+	} else {
+		c.ConsecutiveFails = 0
+		if c.baseline == 0 {
+			c.recent, c.baseline = float64(latency), float64(latency)
+		}
+		c.recent += watchdogRecentAlpha * (float64(latency) - c.recent)
+		c.baseline += watchdogBaselineAlpha * (float64(latency) - c.baseline)
+	}
+	c.RecentErrorRate += watchdogRecentAlpha * (failed - c.RecentErrorRate)
+	c.RecentLatency = time.Duration(c.recent).String()
+	c.BaselineLatency = time.Duration(c.baseline).String()
+
+	var anomalies []string
+	if c.ConsecutiveFails > 0 {
+		anomalies = append(anomalies, anomalyFailing)
+	}
+	if c.Requests >= watchdogWarmup && c.recent > float64(watchdogDriftFloor) && c.recent > watchdogDriftFactor*c.baseline {
+		anomalies = append(anomalies, anomalyLatencyDrift)
+	}
+	if c.Requests >= watchdogWarmup && c.RecentErrorRate > watchdogErrorRate {
+		anomalies = append(anomalies, anomalyErrorRate)
+	}
+
+	for _, a := range anomalies {
+		if !containsString(c.Anomalies, a) {
+			logf(componentWatchdog, "%s %s", c.Path, a)
+			events.publish("watchdog_anomaly", map[string]interface{}{
+				"path":    c.Path,
+				"anomaly": a,
+			})
+		}
+	}
+	c.Anomalies = anomalies
+
+	return c.ConsecutiveFails
+}
+
+func (w *watchdog) report() *watchdogReport {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	r := &watchdogReport{
+		Enabled:   watchdogInterval > 0,
+		Rounds:    w.rounds,
+		LastRound: w.lastRound,
+	}
+	if r.Enabled {
+		r.Interval = watchdogInterval.String()
+	}
+	for _, c := range w.checks {
+		cp := *c
+		cp.Anomalies = append([]string{}, c.Anomalies...)
+		r.Checks = append(r.Checks, &cp)
+		if len(c.Anomalies) > 0 {
+			r.Anomalous = true
+		}
+	}
+	return r
+}
+
+// selfAddr turns a listen address into one the server can dial itself at
+func selfAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// healthHandler answers 200 as long as the server is serving requests
+func healthHandler(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/plain; charset=utf-8")
+	ctx.SetBodyString("ok\n")
+}
+
+// infoHandler reports process details and the watchdog state
+func infoHandler(ctx *fasthttp.RequestCtx) {
+	writeJSON(ctx, fasthttp.StatusOK, map[string]interface{}{
+		"started_at": startTime,
+		"uptime":     time.Since(startTime).Round(time.Second).String(),
+		"go_version": runtime.Version(),
+		"goroutines": runtime.NumGoroutine(),
+		"watchdog":   wd.report(),
+	})
+}
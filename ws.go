@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"expvar"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var wsPongTimeouts = expvar.NewInt("ws_pong_timeouts")
+
+// wsProtocols is the comma separated list of subprotocols the server
+// accepts, in order of preference. When empty the client's first offer is
+// accepted.
+var wsProtocols string
+
+// wsGUID is appended to Sec-WebSocket-Key to compute the accept value
+// (RFC 6455, 1.3)
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// WebSocket close codes
+const (
+	wsCloseNormal        = 1000
+	wsCloseGoingAway     = 1001
+	wsCloseProtocolError = 1002
+	wsCloseTooBig        = 1009
+
+	// Reported by endpoints for a close frame without a code and for a
+	// connection dropped without a close frame, never sent on the wire
+	wsCloseNoStatus = 1005
+	wsCloseAbnormal = 1006
+)
+
+// maxWSReadPayload bounds the messages accepted from clients unless
+// ?max_message= is given, they only send control frames and small
+// messages to these endpoints
+const maxWSReadPayload = 1 << 20
+
+var (
+	errWSFrameTooBig = errors.New("websocket frame too big")
+	errWSProtocol    = errors.New("websocket protocol error")
+)
+
+// wsConn is a server side WebSocket connection, writes may come from
+// several goroutines
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	mu   sync.Mutex
+
+	// Per connection limits, see parseWSLimits
+	maxMessage  int
+	maxFrame    int
+	idleTimeout time.Duration
+	readRate    int64
+
+	// protocol is the negotiated Sec-WebSocket-Protocol, if any
+	protocol string
+
+	// deadline ends reads on top of the idle timeout when set
+	deadline time.Time
+
+	lastPong  int64  // atomic, unix nanoseconds
+	closeCode uint32 // atomic, see recordClose
+
+	// closeSent is set under mu once a close frame went out, there's
+	// only ever one per connection (RFC 6455, 5.5.1)
+	closeSent bool
+}
+
+// Defaults and bounds of the per connection limits
+const (
+	defaultWSReadBuffer = 4 << 10
+	maxWSReadBuffer     = 16 << 20
+)
+
+// parseWSLimits applies ?max_message= (the largest message accepted from
+// the client, 1M by default), ?read_buffer= (4K by default), ?write_buffer=
+// (the largest frame sent, longer messages are fragmented, unlimited by
+// default), ?idle_timeout= (dropping a client that sends nothing for
+// that long) and ?read_rate= (reading at most that many bytes per second,
+// a slow consumer pushing back on the client) to c
+func parseWSLimits(args *fasthttp.Args, c *wsConn) error {
+	maxMessage, err := sizeArg(args, "max_message", maxWSReadPayload)
+	if err != nil || maxMessage > maxWSMessage {
+		return errors.New("max_message must be a byte count up to 16M")
+	}
+	readBuffer, err := sizeArg(args, "read_buffer", defaultWSReadBuffer)
+	if err != nil || readBuffer < 16 || readBuffer > maxWSReadBuffer {
+		return errors.New("read_buffer must be between 16 and 16M")
+	}
+	writeBuffer, err := sizeArg(args, "write_buffer", 0)
+	if err != nil || writeBuffer > maxWSMessage {
+		return errors.New("write_buffer must be a byte count up to 16M")
+	}
+	if v := args.Peek("idle_timeout"); len(v) > 0 {
+		if c.idleTimeout, err = time.ParseDuration(b2s(v)); err != nil || c.idleTimeout < 0 {
+			return errors.New("idle_timeout must be a non-negative duration, e.g. 30s")
+		}
+	}
+
+	if c.readRate, err = sizeArg(args, "read_rate", 0); err != nil || c.readRate < 0 {
+		return errors.New("read_rate must be bytes per second, e.g. 64K or 1M")
+	}
+
+	c.maxMessage = int(maxMessage)
+	c.maxFrame = int(writeBuffer)
+	c.br = bufio.NewReaderSize(nil, int(readBuffer))
+	return nil
+}
+
+// wsUpgrade answers a WebSocket handshake with 101 and runs fn on the
+// hijacked connection, it reports false after answering 400 to requests
+// that aren't valid handshakes
+func wsUpgrade(ctx *fasthttp.RequestCtx, fn func(c *wsConn)) bool {
+	key := ctx.Request.Header.Peek("Sec-WebSocket-Key")
+	if !ctx.IsGet() || len(key) == 0 ||
+		!strings.EqualFold(b2s(ctx.Request.Header.Peek(fasthttp.HeaderUpgrade)), "websocket") ||
+		string(ctx.Request.Header.Peek("Sec-WebSocket-Version")) != "13" {
+		ctx.Error("expected a WebSocket handshake", fasthttp.StatusBadRequest)
+		ctx.Response.Header.Set("Sec-WebSocket-Version", "13")
+		return false
+	}
+
+	c := &wsConn{}
+	if err := parseWSLimits(ctx.QueryArgs(), c); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return false
+	}
+
+	h := sha1.New()
+	h.Write(key)
+	h.Write([]byte(wsGUID))
+
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	ctx.Response.Header.Set(fasthttp.HeaderUpgrade, "websocket")
+	ctx.Response.Header.Set(fasthttp.HeaderConnection, "Upgrade")
+	ctx.Response.Header.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	if c.protocol = selectWSProtocol(&ctx.Request.Header); c.protocol != "" {
+		ctx.Response.Header.Set("Sec-WebSocket-Protocol", c.protocol)
+	}
+
+	ctx.Hijack(func(conn net.Conn) {
+		defer conn.Close()
+		c.conn = conn
+		if c.readRate > 0 {
+			c.br.Reset(&throttledReader{r: conn, rate: c.readRate})
+		} else {
+			c.br.Reset(conn)
+		}
+		defer trackWSConn(c)()
+		// Tells clients which subprotocol they got without looking at
+		// the handshake
+		if c.protocol != "" {
+			if err := c.writeFrame(wsOpText, []byte("protocol="+c.protocol)); err != nil {
+				return
+			}
+		}
+		fn(c)
+	})
+	return true
+}
+
+// selectWSProtocol picks the subprotocol among those offered in the
+// Sec-WebSocket-Protocol headers: the first offer, or with -ws-protocols
+// the most preferred one the client offered. It's empty when nothing
+// matches, the handshake then goes on without a subprotocol.
+func selectWSProtocol(h *fasthttp.RequestHeader) string {
+	var offered []string
+	h.VisitAll(func(k, v []byte) {
+		if !strings.EqualFold(b2s(k), "Sec-WebSocket-Protocol") {
+			return
+		}
+		for _, p := range strings.Split(string(v), ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				offered = append(offered, p)
+			}
+		}
+	})
+	if len(offered) == 0 {
+		return ""
+	}
+	if wsProtocols == "" {
+		return offered[0]
+	}
+	for _, p := range strings.Split(wsProtocols, ",") {
+		if p = strings.TrimSpace(p); containsString(offered, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// writeFrame sends payload as a single unmasked frame, data messages
+// longer than ?write_buffer= as several
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	if op < wsOpClose && c.maxFrame > 0 && len(payload) > c.maxFrame {
+		return c.writeFragmented(op, payload, (len(payload)+c.maxFrame-1)/c.maxFrame, false)
+	}
+	return c.writeFragment(true, op, payload)
+}
+
+// writeFragment sends payload as an unmasked frame, the final one of its
+// message when fin is set. Fragments after the first use
+// wsOpContinuation. Close frames after the first are dropped.
+func (c *wsConn) writeFragment(fin bool, op byte, payload []byte) error {
+	var buf [10]byte
+	header := buf[:2]
+	header[0] = op
+	if fin {
+		header[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = buf[:4]
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = buf[:10]
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if op == wsOpClose {
+		if c.closeSent {
+			return nil
+		}
+		c.closeSent = true
+	}
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.conn.Write(payload); err != nil {
+		return err
+	}
+
+	switch {
+	case op == wsOpClose:
+		c.recordClose(payload)
+	case op < wsOpClose:
+		wsBytesOut.Add(int64(len(payload)))
+		if fin {
+			wsMessagesOut.Add(1)
+		}
+	}
+	return nil
+}
+
+// writeClose sends a close frame with the given status code and reason
+func (c *wsConn) writeClose(code uint16, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	return c.writeFrame(wsOpClose, append(payload, reason...))
+}
+
+// readFrame reads the next frame from the client and unmasks it
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	deadline := c.deadline
+	if c.idleTimeout > 0 {
+		if idle := time.Now().Add(c.idleTimeout); deadline.IsZero() || idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+	c.conn.SetReadDeadline(deadline)
+
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0f
+
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > uint64(c.maxMessage) {
+		return false, 0, nil, errWSFrameTooBig
+	}
+
+	var mask [4]byte
+	masked := head[1]&0x80 != 0
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// readMessage reads the next data message from the client, reassembling
+// its fragments. Control frames in between are handled: pings answered,
+// pongs recorded and a close echoed unless the server sent one first, after
+// which io.EOF is returned.
+func (c *wsConn) readMessage() (op byte, msg []byte, err error) {
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err == errWSFrameTooBig {
+			c.writeClose(wsCloseTooBig, "")
+			return 0, nil, err
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frameOp {
+		case wsOpPong:
+			atomic.StoreInt64(&c.lastPong, time.Now().UnixNano())
+			continue
+		case wsOpPing:
+			c.writeFrame(wsOpPong, payload)
+			continue
+		case wsOpClose:
+			c.recordClose(payload)
+			c.writeFrame(wsOpClose, payload)
+			return 0, nil, io.EOF
+		case wsOpContinuation:
+			if op == 0 {
+				c.writeClose(wsCloseProtocolError, "")
+				return 0, nil, errWSProtocol
+			}
+			msg = append(msg, payload...)
+		default:
+			op, msg = frameOp, payload
+		}
+		wsBytesIn.Add(int64(len(payload)))
+
+		if len(msg) > c.maxMessage {
+			c.writeClose(wsCloseTooBig, "")
+			return 0, nil, errWSFrameTooBig
+		}
+		if fin {
+			wsMessagesIn.Add(1)
+			return op, msg, nil
+		}
+	}
+}
+
+// readLoop discards the client's messages and returns once it closes the
+// connection or sends a close frame
+func (c *wsConn) readLoop() {
+	for {
+		if _, _, err := c.readMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// wsKeepaliveArgs parses ?ping_interval=&pong_timeout=, durations such as
+// 30s. The pong timeout defaults to the ping interval.
+func wsKeepaliveArgs(args *fasthttp.Args) (interval, timeout time.Duration, err error) {
+	if v := args.Peek("ping_interval"); len(v) > 0 {
+		if interval, err = time.ParseDuration(b2s(v)); err != nil || interval < 0 {
+			return 0, 0, errors.New("ping_interval must be a non-negative duration, e.g. 30s")
+		}
+	}
+	timeout = interval
+	if v := args.Peek("pong_timeout"); len(v) > 0 {
+		if timeout, err = time.ParseDuration(b2s(v)); err != nil || timeout < 0 {
+			return 0, 0, errors.New("pong_timeout must be a non-negative duration, e.g. 10s")
+		}
+	}
+	return interval, timeout, nil
+}
+
+// keepalive pings the client every interval until done is closed. A
+// client that doesn't answer a ping within timeout is dropped by closing
+// the connection, a zero timeout never drops. The next ping is due an
+// interval after the previous one, or after its deadline if that's later.
+func (c *wsConn) keepalive(interval, timeout time.Duration, done <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		sent := time.Now()
+		if err := c.writeFrame(wsOpPing, nil); err != nil {
+			return
+		}
+		if timeout <= 0 {
+			continue
+		}
+
+		deadline := time.NewTimer(timeout)
+		select {
+		case <-done:
+			deadline.Stop()
+			return
+		case <-deadline.C:
+		}
+		if atomic.LoadInt64(&c.lastPong) < sent.UnixNano() {
+			wsPongTimeouts.Add(1)
+			logf(componentWS, "websocket %s dropped, no pong within %s", c.conn.RemoteAddr(), timeout)
+			c.conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// dialWS completes a WebSocket handshake for path and returns the client
+// side as a wsConn, which reads the server's unmasked frames
+func dialWS(t *testing.T, path string) *wsConn {
+	t.Helper()
+
+	c := dialRaw(t, serveTest(t))
+	req := newRequest(t, "GET", path, nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(c); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status = %d, want 101", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Sec-WebSocket-Accept = %q", got)
+	}
+	return &wsConn{conn: c, br: br, maxMessage: maxWSReadPayload, deadline: time.Now().Add(5 * time.Second)}
+}
+
+// writeMasked sends a single masked frame as a client must
+func writeMasked(t *testing.T, c net.Conn, op byte, payload []byte) {
+	t.Helper()
+
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | op, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := c.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func closePayload(code uint16, reason string) []byte {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	return append(payload, reason...)
+}
+
+// readClose reads frames up to the server's close frame, skipping data
+// messages, and returns its code and reason
+func readClose(t *testing.T, c *wsConn) (uint16, string) {
+	t.Helper()
+
+	for {
+		_, op, payload, err := c.readFrame()
+		if err != nil {
+			t.Fatalf("reading up to the close frame: %v", err)
+		}
+		if op != wsOpClose {
+			continue
+		}
+		if len(payload) < 2 {
+			t.Fatalf("close frame without a code: %q", payload)
+		}
+		return binary.BigEndian.Uint16(payload), string(payload[2:])
+	}
+}
+
+// expectEOF checks that nothing, a second close frame in particular,
+// follows before the server closes the connection
+func expectEOF(t *testing.T, c *wsConn) {
+	t.Helper()
+
+	if _, op, payload, err := c.readFrame(); err != io.EOF {
+		t.Errorf("after the close handshake: op %#x payload %q err %v, want io.EOF", op, payload, err)
+	}
+}
+
+func TestWSCloseHandshake(t *testing.T) {
+	c := dialWS(t, "/ws/close?code=4001&reason=try+later")
+
+	code, reason := readClose(t, c)
+	if code != 4001 || reason != "try later" {
+		t.Errorf("close = %d %q, want 4001 %q", code, reason, "try later")
+	}
+	// The client's reply with another code must not be echoed
+	writeMasked(t, c.conn, wsOpClose, closePayload(wsCloseNormal, "bye"))
+	expectEOF(t, c)
+}
+
+func TestWSCloseAfterMessages(t *testing.T) {
+	c := dialWS(t, "/ws/close?after_messages=1")
+
+	writeMasked(t, c.conn, wsOpText, []byte("hi"))
+	if _, op, payload, err := c.readFrame(); err != nil || op != wsOpText || string(payload) != "hi" {
+		t.Fatalf("echo = %#x %q %v", op, payload, err)
+	}
+	if code, reason := readClose(t, c); code != wsCloseNormal || reason != "server done" {
+		t.Errorf("close = %d %q, want 1000 %q", code, reason, "server done")
+	}
+	writeMasked(t, c.conn, wsOpClose, closePayload(wsCloseNormal, ""))
+	expectEOF(t, c)
+}
+
+func TestWSClientInitiatedClose(t *testing.T) {
+	c := dialWS(t, "/ws/close?after_messages=10")
+
+	writeMasked(t, c.conn, wsOpClose, closePayload(wsCloseGoingAway, "leaving"))
+	if code, reason := readClose(t, c); code != wsCloseGoingAway || reason != "leaving" {
+		t.Errorf("echoed close = %d %q, want 1001 %q", code, reason, "leaving")
+	}
+	expectEOF(t, c)
+}
+
+func TestWSBinClosesOnce(t *testing.T) {
+	c := dialWS(t, "/ws/bin?total=10&msg=4")
+
+	var got []byte
+	for len(got) < 10 {
+		_, op, payload, err := c.readFrame()
+		if err != nil || op != wsOpBinary {
+			t.Fatalf("frame after %d bytes: op %#x err %v", len(got), op, err)
+		}
+		got = append(got, payload...)
+	}
+	if string(got) != string(patternBytes(0, 10)) {
+		t.Errorf("payload = %q", got)
+	}
+	if code, _ := readClose(t, c); code != wsCloseNormal {
+		t.Errorf("close code = %d, want 1000", code)
+	}
+	writeMasked(t, c.conn, wsOpClose, closePayload(wsCloseNormal, ""))
+	expectEOF(t, c)
+}
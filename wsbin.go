@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// maxWSMessage bounds the message size of /ws/bin
+const maxWSMessage = 16 << 20
+
+// wsCloseWait is how long /ws/bin waits for the client's close frame
+// after sending its own
+const wsCloseWait = time.Second
+
+// wsBinHandler serves /ws/bin?total=&msg=&rate=, the WebSocket counterpart
+// of /bin: binary messages of msg bytes of the /bin pattern (64K by
+// default) are pushed until total bytes (1M by default) are sent, at most
+// rate bytes per second when given, then the connection is closed.
+// ?ping_interval=&pong_timeout= ping the client and drop it when it stops
+// answering.
+func wsBinHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	total, err := sizeArg(args, "total", 1<<20)
+	if err != nil {
+		ctx.Error("total must be a byte count, e.g. 64K or 1G", fasthttp.StatusBadRequest)
+		return
+	}
+	msg, err := sizeArg(args, "msg", 64<<10)
+	if err != nil || msg < 1 || msg > maxWSMessage {
+		ctx.Error("msg must be between 1 and 16M", fasthttp.StatusBadRequest)
+		return
+	}
+	rate, err := sizeArg(args, "rate", 0)
+	if err != nil {
+		ctx.Error("rate must be bytes per second, e.g. 64K or 1M", fasthttp.StatusBadRequest)
+		return
+	}
+
+	pingInterval, pongTimeout, err := wsKeepaliveArgs(args)
+	if err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	wsUpgrade(ctx, func(c *wsConn) {
+		closed := make(chan struct{})
+		go func() {
+			c.readLoop()
+			close(closed)
+		}()
+		go c.keepalive(pingInterval, pongTimeout, closed)
+
+		buf := make([]byte, msg)
+		start := time.Now()
+		var sent int64
+		for sent < total {
+			if rate > 0 {
+				// Sleep to absolute deadlines so slow writes don't
+				// stretch the total duration
+				due := start.Add(time.Duration(float64(sent) / float64(rate) * float64(time.Second)))
+				if !sleepStream(time.Until(due)) {
+					c.writeClose(wsCloseGoingAway, "")
+					return
+				}
+			}
+			select {
+			case <-closed:
+				return
+			case <-draining:
+				c.writeClose(wsCloseGoingAway, "")
+				return
+			default:
+			}
+
+			n := msg
+			if total-sent < n {
+				n = total - sent
+			}
+			io.ReadFull(newPatternReader(sent, n), buf[:n])
+			if err := c.writeFrame(wsOpBinary, buf[:n]); err != nil {
+				return
+			}
+			sent += n
+		}
+
+		c.writeClose(wsCloseNormal, "")
+		select {
+		case <-closed:
+		case <-time.After(wsCloseWait):
+		}
+	})
+}
+
+// sizeArg returns the byte count query argument name, or def when it's
+// missing
+func sizeArg(args *fasthttp.Args, name string, def int64) (int64, error) {
+	v := args.Peek(name)
+	if len(v) == 0 {
+		return def, nil
+	}
+	return parseSize(b2s(v))
+}
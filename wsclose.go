@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+	"unicode/utf8"
+
+	"github.com/valyala/fasthttp"
+)
+
+// maxWSCloseReason is the longest reason fitting a control frame
+const maxWSCloseReason = 123
+
+// wsCloseHandler serves /ws/close?code=&reason=&after_messages=&after=, an
+// echo that closes the connection with code (1000 by default) and reason
+// ("server done" by default) once after_messages messages were echoed or
+// the after duration passed, whichever comes first, right away without
+// either. Code 1006 drops the TCP connection without a close frame, like
+// an abnormal closure.
+func wsCloseHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	code, err := intArg(args, "code", wsCloseNormal)
+	if err != nil || code < 1000 || code > 4999 {
+		ctx.Error("code must be a close code between 1000 and 4999", fasthttp.StatusBadRequest)
+		return
+	}
+	reason := "server done"
+	if args.Has("reason") {
+		reason = string(args.Peek("reason"))
+	}
+	if len(reason) > maxWSCloseReason || !utf8.ValidString(reason) {
+		ctx.Error("reason must be valid UTF-8 of up to 123 bytes", fasthttp.StatusBadRequest)
+		return
+	}
+	afterMessages, err := intArg(args, "after_messages", 0)
+	if err != nil || afterMessages < 0 {
+		ctx.Error("after_messages must be a non-negative number", fasthttp.StatusBadRequest)
+		return
+	}
+	var after time.Duration
+	if v := args.Peek("after"); len(v) > 0 {
+		if after, err = time.ParseDuration(b2s(v)); err != nil || after < 0 {
+			ctx.Error("after must be a non-negative duration, e.g. 5s", fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	wsUpgrade(ctx, func(c *wsConn) {
+		if afterMessages > 0 || after > 0 {
+			var ne net.Error
+			if err := c.echoUntil(afterMessages, after); err != nil && !(errors.As(err, &ne) && ne.Timeout()) {
+				return
+			}
+		}
+
+		// Returning closes the TCP connection without a close frame
+		if code == wsCloseAbnormal {
+			return
+		}
+		// The client's reply isn't echoed, the close sent here is the
+		// only one on the wire
+		c.writeClose(uint16(code), reason)
+		c.deadline = time.Now().Add(wsCloseWait)
+		c.readLoop()
+	})
+}
+
+// echoUntil echoes messages until n were echoed, unless n is 0, or d has
+// passed, unless d is 0, which ends with a timeout error
+func (c *wsConn) echoUntil(n int, d time.Duration) error {
+	if d > 0 {
+		// An expired deadline ends the blocked read
+		c.deadline = time.Now().Add(d)
+		defer func() { c.deadline = time.Time{} }()
+	}
+
+	for i := 0; n == 0 || i < n; i++ {
+		op, msg, err := c.readMessage()
+		if err != nil {
+			return err
+		}
+		if err := c.writeFrame(op, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"expvar"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+var wsFlakyDrops = expvar.NewMap("ws_flaky_drops")
+
+// wsFlakyCheckInterval is the time between the drop draws of /ws/flaky
+const wsFlakyCheckInterval = time.Second
+
+// wsFlakyHandler serves /ws/flaky?drop_prob=&min_lifetime=, an echo that
+// after min_lifetime (5s by default) drops the connection with probability
+// drop_prob (0.01 by default) every second. A drop is a close frame with
+// 1001, a TCP close without a close frame or a TCP reset, picked at random
+// and counted in ws_flaky_drops, for testing client reconnect logic.
+func wsFlakyHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	dropProb := 0.01
+	if v := args.Peek("drop_prob"); len(v) > 0 {
+		p, err := strconv.ParseFloat(b2s(v), 64)
+		if err != nil || p < 0 || p > 1 {
+			ctx.Error("drop_prob must be between 0 and 1", fasthttp.StatusBadRequest)
+			return
+		}
+		dropProb = p
+	}
+	minLifetime := 5 * time.Second
+	if v := args.Peek("min_lifetime"); len(v) > 0 {
+		d, err := time.ParseDuration(b2s(v))
+		if err != nil || d < 0 {
+			ctx.Error("min_lifetime must be a non-negative duration, e.g. 5s", fasthttp.StatusBadRequest)
+			return
+		}
+		minLifetime = d
+	}
+
+	// The hijacked connection is wrapped, resets need the TCP one
+	raw := ctx.Conn()
+	wsUpgrade(ctx, func(c *wsConn) {
+		closed := make(chan struct{})
+		go func() {
+			c.echoUntil(0, 0)
+			close(closed)
+		}()
+
+		start := time.Now()
+		ticker := time.NewTicker(wsFlakyCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closed:
+				return
+			case <-draining:
+				c.writeClose(wsCloseGoingAway, "")
+				return
+			case <-ticker.C:
+			}
+			if time.Since(start) < minLifetime || rand.Float64() >= dropProb {
+				continue
+			}
+
+			kind := [...]string{"close", "fin", "rst"}[rand.Intn(3)]
+			wsFlakyDrops.Add(kind, 1)
+			logf(componentWS, "/ws/flaky dropped %s after %s with %s", c.conn.RemoteAddr(), time.Since(start).Round(time.Millisecond), kind)
+			switch kind {
+			case "close":
+				c.writeClose(wsCloseGoingAway, "flaky")
+				select {
+				case <-closed:
+				case <-time.After(wsCloseWait):
+				}
+			case "rst":
+				resetConn(raw)
+			}
+			// Returning closes the TCP connection
+			return
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+)
+
+// maxWSFragments bounds ?fragments= of /ws/fragmented
+const maxWSFragments = 1024
+
+// wsFragmentedHandler serves /ws/fragmented?fragments=N&interleave=, an
+// echo that sends every message back split into N frames: the first with
+// the message's opcode and the rest continuation frames, fragments of
+// short messages may be empty. ?interleave=true puts a ping between the
+// fragments, as control frames may be, to test reassembly in clients and
+// intermediaries.
+func wsFragmentedHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	fragments, err := intArg(args, "fragments", 2)
+	if err != nil || fragments < 1 || fragments > maxWSFragments {
+		ctx.Error("fragments must be between 1 and "+strconv.Itoa(maxWSFragments), fasthttp.StatusBadRequest)
+		return
+	}
+	interleave := args.GetBool("interleave")
+
+	wsUpgrade(ctx, func(c *wsConn) {
+		for {
+			op, msg, err := c.readMessage()
+			if err != nil {
+				return
+			}
+			if err := c.writeFragmented(op, msg, fragments, interleave); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// writeFragmented sends msg as n fragments of nearly equal size
+func (c *wsConn) writeFragmented(op byte, msg []byte, n int, interleave bool) error {
+	size := (len(msg) + n - 1) / n
+	for i := 0; i < n; i++ {
+		start, end := i*size, (i+1)*size
+		if start > len(msg) {
+			start = len(msg)
+		}
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		if i > 0 {
+			op = wsOpContinuation
+			if interleave {
+				if err := c.writeFrame(wsOpPing, nil); err != nil {
+					return err
+				}
+			}
+		}
+		if err := c.writeFragment(i == n-1, op, msg[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
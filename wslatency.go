@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// wsLatencyReply answers every message of /ws/latency
+type wsLatencyReply struct {
+	Seq    int   `json:"seq"`
+	Size   int   `json:"size"`
+	RecvNS int64 `json:"server_recv_ns"`
+	SendNS int64 `json:"server_send_ns"`
+}
+
+// wsLatencyHandler serves /ws/latency, answering every message with a
+// JSON text message holding its sequence number, starting at 0, its size
+// and the server's receive and send times in Unix nanoseconds. Comparing
+// them with the client's clocks gives the round trip and, with synced
+// clocks, each one-way latency through WebSocket terminating proxies.
+func wsLatencyHandler(ctx *fasthttp.RequestCtx) {
+	wsUpgrade(ctx, func(c *wsConn) {
+		for seq := 0; ; seq++ {
+			_, msg, err := c.readMessage()
+			if err != nil {
+				return
+			}
+			reply := wsLatencyReply{
+				Seq:    seq,
+				Size:   len(msg),
+				RecvNS: time.Now().UnixNano(),
+			}
+
+			reply.SendNS = time.Now().UnixNano()
+			buf, err := json.Marshal(&reply)
+			if err != nil {
+				return
+			}
+			if err := c.writeFrame(wsOpText, buf); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Bounds of /ws/push
+const (
+	maxWSPushRate     = 100000
+	maxWSPushDuration = time.Hour
+)
+
+// wsPushHandler serves /ws/push?rate=&size=&duration=, rate unsolicited
+// text messages per second (10 by default) of size bytes (512 by default)
+// for duration (60s by default), then a normal close. Each message starts
+// with "seq=N " and is padded with the /bin pattern, so lost or reordered
+// notifications show through gateways.
+func wsPushHandler(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	rate, err := intArg(args, "rate", 10)
+	if err != nil || rate < 1 || rate > maxWSPushRate {
+		ctx.Error("rate must be between 1 and "+strconv.Itoa(maxWSPushRate)+" messages per second", fasthttp.StatusBadRequest)
+		return
+	}
+	size, err := sizeArg(args, "size", 512)
+	if err != nil || size > maxWSMessage {
+		ctx.Error("size must be a byte count up to 16M", fasthttp.StatusBadRequest)
+		return
+	}
+	duration := 60 * time.Second
+	if v := args.Peek("duration"); len(v) > 0 {
+		if duration, err = time.ParseDuration(b2s(v)); err != nil || duration < 0 || duration > maxWSPushDuration {
+			ctx.Error("duration must be a duration up to "+maxWSPushDuration.String(), fasthttp.StatusBadRequest)
+			return
+		}
+	}
+
+	wsUpgrade(ctx, func(c *wsConn) {
+		closed := make(chan struct{})
+		go func() {
+			c.readLoop()
+			close(closed)
+		}()
+
+		interval := time.Second / time.Duration(rate)
+		start := time.Now()
+		end := start.Add(duration)
+		msg := make([]byte, 0, size)
+		for seq := 0; ; seq++ {
+			// Sleep to absolute deadlines so slow writes don't lower
+			// the rate
+			next := start.Add(time.Duration(seq) * interval)
+			if !next.Before(end) {
+				break
+			}
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+			case <-closed:
+				timer.Stop()
+				return
+			case <-draining:
+				timer.Stop()
+				c.writeClose(wsCloseGoingAway, "")
+				return
+			}
+
+			msg = strconv.AppendInt(append(msg[:0], "seq="...), int64(seq), 10)
+			msg = append(msg, ' ')
+			for int64(len(msg)) < size {
+				msg = append(msg, pattern[len(msg)%len(pattern)])
+			}
+			if int64(len(msg)) > size {
+				msg = msg[:size]
+			}
+			if err := c.writeFrame(wsOpText, msg); err != nil {
+				return
+			}
+		}
+
+		c.writeClose(wsCloseNormal, "")
+		select {
+		case <-closed:
+		case <-time.After(wsCloseWait):
+		}
+	})
+}
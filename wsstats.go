@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/binary"
+	"expvar"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/valyala/fasthttp"
+)
+
+// WebSocket counters, published via expvar and served at /ws/stats
+var (
+	wsConnectionsOpen  = expvar.NewInt("ws_connections_open")
+	wsConnectionsTotal = expvar.NewInt("ws_connections_total")
+	wsMessagesIn       = expvar.NewInt("ws_messages_in")
+	wsMessagesOut      = expvar.NewInt("ws_messages_out")
+	wsBytesIn          = expvar.NewInt("ws_bytes_in")
+	wsBytesOut         = expvar.NewInt("ws_bytes_out")
+	wsCloseCodes       = expvar.NewMap("ws_close_codes")
+)
+
+// recordClose remembers the code of the first close frame sent or
+// received on c, the one the connection ends with
+func (c *wsConn) recordClose(payload []byte) {
+	code := uint32(wsCloseNoStatus)
+	if len(payload) >= 2 {
+		code = uint32(binary.BigEndian.Uint16(payload))
+	}
+	atomic.CompareAndSwapUint32(&c.closeCode, 0, code)
+}
+
+// trackWSConn counts a new connection and returns the function that
+// counts it closed, under its close code or 1006 without a close frame
+func trackWSConn(c *wsConn) func() {
+	wsConnectionsTotal.Add(1)
+	wsConnectionsOpen.Add(1)
+	return func() {
+		wsConnectionsOpen.Add(-1)
+		code := atomic.LoadUint32(&c.closeCode)
+		if code == 0 {
+			code = wsCloseAbnormal
+		}
+		wsCloseCodes.Add(strconv.Itoa(int(code)), 1)
+	}
+}
+
+// wsStatsHandler serves /ws/stats, the WebSocket counters as JSON
+func wsStatsHandler(ctx *fasthttp.RequestCtx) {
+	closeCodes := make(map[string]int64)
+	wsCloseCodes.Do(func(kv expvar.KeyValue) {
+		closeCodes[kv.Key] = kv.Value.(*expvar.Int).Value()
+	})
+
+	writeJSON(ctx, fasthttp.StatusOK, map[string]interface{}{
+		"connections_open":  wsConnectionsOpen.Value(),
+		"connections_total": wsConnectionsTotal.Value(),
+		"messages_in":       wsMessagesIn.Value(),
+		"messages_out":      wsMessagesOut.Value(),
+		"bytes_in":          wsBytesIn.Value(),
+		"bytes_out":         wsBytesOut.Value(),
+		"close_codes":       closeCodes,
+	})
+}